@@ -0,0 +1,133 @@
+package prpl
+
+import (
+	"time"
+
+	"encoding/json"
+	"net/http"
+)
+
+type (
+	// buildStatus is the admin report for a single build
+	buildStatus struct {
+		Name         string    `json:"name"`
+		Requirements []string  `json:"requirements"`
+		Entrypoint   string    `json:"entrypoint"`
+		PushHeaders  int       `json:"pushHeaders"`
+		Loaded       time.Time `json:"loaded"`
+	}
+
+	// adminStatus is the admin report for the current builds
+	adminStatus struct {
+		Loaded time.Time        `json:"loaded"`
+		Builds []*buildStatus   `json:"builds"`
+		Errors ValidationErrors `json:"errors"`
+	}
+
+	// reloadStatus is the admin report after a reload
+	reloadStatus struct {
+		Reloaded bool `json:"reloaded"`
+		*adminStatus
+	}
+
+	// buildErrorJSON is the JSON representation of a BuildError
+	buildErrorJSON struct {
+		Build string `json:"build"`
+		Path  string `json:"path,omitempty"`
+		Fatal bool   `json:"fatal"`
+		Error string `json:"error"`
+	}
+)
+
+// AdminHandler returns a handler exposing the state of the builds
+// which can be mounted separately from the app, for instance on an
+// internal port or behind authentication:
+//
+//	GET /builds  reports the builds currently being served
+//	POST /reload reloads the builds and reports the result
+func (p *prpl) AdminHandler() http.Handler {
+	m := http.NewServeMux()
+
+	m.HandleFunc("/builds", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newAdminStatus(p.generation()))
+	})
+
+	m.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		g, err := p.reload(r.Context())
+		if err == nil {
+			writeJSON(w, http.StatusOK, &reloadStatus{true, newAdminStatus(g)})
+			return
+		}
+
+		// report the errors along with the builds still being served
+		status := &reloadStatus{false, newAdminStatus(p.generation())}
+		code := http.StatusInternalServerError
+		if report, ok := err.(ValidationErrors); ok {
+			status.Errors = report
+			code = http.StatusUnprocessableEntity
+		} else {
+			status.Errors = ValidationErrors{{Fatal: true, Err: err}}
+		}
+		writeJSON(w, code, status)
+	})
+
+	return m
+}
+
+func newAdminStatus(g *generation) *adminStatus {
+	status := &adminStatus{
+		Loaded: g.loaded,
+		Builds: make([]*buildStatus, len(g.builds)),
+		Errors: g.report,
+	}
+	if status.Errors == nil {
+		status.Errors = ValidationErrors{}
+	}
+
+	for i, build := range g.builds {
+		pushHeaders := 0
+		for _, resources := range build.pushHeaders {
+			pushHeaders += len(resources)
+		}
+		status.Builds[i] = &buildStatus{
+			Name:         build.name,
+			Requirements: build.requirements.names(),
+			Entrypoint:   build.entrypoint,
+			PushHeaders:  pushHeaders,
+			Loaded:       g.loaded,
+		}
+	}
+
+	return status
+}
+
+// MarshalJSON includes the error message in the JSON output
+func (e *BuildError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&buildErrorJSON{
+		Build: e.Build,
+		Path:  e.Path,
+		Fatal: e.Fatal,
+		Error: e.Err.Error(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,76 @@
+package prpl
+
+import (
+	"os"
+	"testing"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+)
+
+func TestAdminHandler(t *testing.T) {
+	dir := copyDir(t, "testdata/app")
+	defer os.RemoveAll(dir)
+
+	p, err := New(
+		WithRoot(http.Dir(dir)),
+		WithConfigFile(filepath.Join(dir, "polymer.json")),
+		WithRoutes(Routes{"/view1": "src/my-view1.html"}),
+		sharedFiles(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := p.AdminHandler()
+
+	request := func(method, path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	code, body := request("GET", "/builds")
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200: got %d", code)
+	}
+	builds := body["builds"].([]interface{})
+	if len(builds) != 2 {
+		t.Fatalf("expected 2 builds: got %v", builds)
+	}
+	modern := builds[0].(map[string]interface{})
+	if modern["name"] != "modern" || modern["entrypoint"] != "modern/index.html" || modern["pushHeaders"].(float64) == 0 {
+		t.Errorf("unexpected build status %v", modern)
+	}
+	if requirements := modern["requirements"].([]interface{}); len(requirements) != 2 || requirements[0] != "es2015" {
+		t.Errorf("unexpected requirements %v", requirements)
+	}
+
+	if code, _ := request("GET", "/reload"); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405: got %d", code)
+	}
+
+	code, body = request("POST", "/reload")
+	if code != http.StatusOK || body["reloaded"] != true {
+		t.Errorf("expected reload to succeed: got %d %v", code, body)
+	}
+
+	// a broken build is reported and the previous builds kept
+	if err := os.Remove(filepath.Join(dir, "modern", "index.html")); err != nil {
+		t.Fatal(err)
+	}
+	code, body = request("POST", "/reload")
+	if code != http.StatusUnprocessableEntity || body["reloaded"] != false {
+		t.Errorf("expected reload to fail: got %d %v", code, body)
+	}
+	errs := body["errors"].([]interface{})
+	if len(errs) != 1 || errs[0].(map[string]interface{})["build"] != "modern" {
+		t.Errorf("expected error for modern build: got %v", errs)
+	}
+	if builds := body["builds"].([]interface{}); len(builds) != 2 {
+		t.Errorf("expected previous builds to be reported: got %v", builds)
+	}
+}
@@ -3,8 +3,12 @@ package prpl
 import (
 	"fmt"
 	"log"
+	"mime"
 	"os"
+	"path"
+	"regexp"
 	"sort"
+	"strings"
 	"time"
 
 	"io/ioutil"
@@ -14,12 +18,16 @@ import (
 
 type (
 	build struct {
-		name         string
-		configOrder  int
-		requirements capability
-		entrypoint   string
-		template     Template
-		pushHeaders  PushHeaders
+		name            string
+		configOrder     int
+		requirements    capability
+		entrypoint      string
+		template        Template
+		routes          routeTable
+		pushHeaders     PushHeaders
+		documentHeaders PushHeaders
+		notFoundPage    []byte
+		files           map[string]*file
 	}
 
 	builds []*build
@@ -28,51 +36,94 @@ type (
 		data    []byte
 		size    int64
 		modTime time.Time
+		etag    string
 	}
 
-	// TODO: add all headers including cache-control and
-	// service worker so no regex matching is needed at runtime
+	// pushResource is a resource to push or preload along
+	// with the content type and cache headers recorded at
+	// load time for use in the push promise
+	pushResource struct {
+		url    string
+		link   string
+		header http.Header
+	}
 
-	// PushHeaders are the link headers to send for a route
-	PushHeaders map[string][]string
+	// PushHeaders are the push resources to send for a route
+	// pattern, or for the url of a push manifest entry
+	PushHeaders map[string][]*pushResource
 )
 
-var files = make(map[string]*file)
-
-func loadBuilds(config *ProjectConfig, root http.Dir, routes Routes, createTemplate createTemplateFn) builds {
+// loadBuilds loads every build in the configuration. Problems are
+// collected into a validation report which is returned as an error
+// in strict mode, otherwise builds that cannot be served are dropped
+// and the report is returned as warnings.
+func (p *prpl) loadBuilds(config *ProjectConfig, routes Routes) (builds, ValidationErrors, error) {
 	builds := builds{}
+	report := ValidationErrors{}
 	entrypoint := "index.html"
 	if config != nil && config.Entrypoint != "" {
 		entrypoint = config.Entrypoint
 	}
 
+	patterns := make([]string, 0, len(routes))
+	for pattern := range routes {
+		patterns = append(patterns, pattern)
+	}
+	table, err := compileRoutes(patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	if config == nil || len(config.Builds) == 0 {
 		log.Println("WARNING: No builds configured")
-		builds = append(builds, newBuild(config, 0, "", 0, entrypoint, string(root), root, routes, createTemplate))
+		if build, errs := p.newBuild(config, routes, 0, "", 0, nil, entrypoint, string(p.root)); build != nil {
+			builds = append(builds, build)
+			report = append(report, errs...)
+		} else {
+			report = append(report, errs...)
+		}
 	} else {
-		for i, build := range config.Builds {
-			if build.Name == "" {
-				log.Printf("WARNING: Build at offset %d has no name; skipping.\n", i)
+		for i, buildConfig := range config.Builds {
+			if buildConfig.Name == "" {
+				report.add("", "", true, fmt.Errorf("build at offset %d has no name", i))
 				continue
 			}
-			builds = append(builds, newBuild(config, i, build.Name, newCapabilities(build.BrowserCapabilities), filepath.Join(build.Name, entrypoint), filepath.Join(string(root), build.Name), root, routes, createTemplate))
+			requirements, err := newCapabilities(buildConfig.BrowserCapabilities)
+			if err != nil {
+				return nil, nil, fmt.Errorf("build %q: %v", buildConfig.Name, err)
+			}
+			build, errs := p.newBuild(config, routes, i, buildConfig.Name, requirements, buildConfig.Preload, filepath.Join(buildConfig.Name, entrypoint), filepath.Join(string(p.root), buildConfig.Name))
+			report = append(report, errs...)
+			if build != nil {
+				builds = append(builds, build)
+			}
 		}
 	}
 
+	if len(report) > 0 && p.strict {
+		return nil, report, report
+	}
+
+	for _, err := range report {
+		if err.Fatal {
+			log.Printf("WARNING: %v; skipping.\n", err)
+		} else {
+			log.Printf("WARNING: %v\n", err)
+		}
+	}
+
+	if len(builds) == 0 {
+		return nil, report, fmt.Errorf("no builds could be loaded: %v", report)
+	}
+
+	for _, build := range builds {
+		build.routes = table
+	}
 	sort.Sort(byPriority(builds))
 
 	// Sanity check.
 	fallbackFound := false
 	for _, build := range builds {
-
-		// Note `build.entrypoint` is relative to the server root, but that's not
-		// neccessarily our cwd.
-		// TODO Refactor to make filepath vs URL path and relative vs absolute
-		// values clearer.
-		// if (!fs.existsSync(path.join(root, build.entrypoint))) {
-		//   console.warn(`WARNING: Entrypoint "${build.entrypoint}" does not exist.`);
-		// }
-
 		if build.requirements == 0 {
 			fallbackFound = true
 		}
@@ -82,9 +133,13 @@ func loadBuilds(config *ProjectConfig, root http.Dir, routes Routes, createTempl
 		log.Println("WARNING: All builds have a capability requirement. Some browsers will display an error. Consider a fallback build.")
 	}
 
-	return builds
+	return builds, report, nil
 }
 
+// defaultPreload is preloaded for every route if the build doesn't
+// configure the resources to preload and it is present in the build
+const defaultPreload = "bower_components/webcomponentsjs/webcomponents-loader.js"
+
 type byPriority builds
 
 func (a byPriority) Len() int      { return len(a) }
@@ -97,16 +152,33 @@ func (a byPriority) Less(i, j int) bool {
 	return sizeDiff > 0
 }
 
-func newBuild(config *ProjectConfig, configOrder int, name string, requirements capability, entrypoint, buildDir string, root http.Dir, routes Routes, createTemplate createTemplateFn) *build {
+// newBuild loads a single build. The build is nil if it has a
+// fatal error, so a build is never created without a template.
+func (p *prpl) newBuild(config *ProjectConfig, routes Routes, configOrder int, name string, requirements capability, preload []string, entrypoint, buildDir string) (*build, ValidationErrors) {
+	errs := ValidationErrors{}
+
+	// Note `entrypoint` is relative to the server root, but that's not
+	// neccessarily our cwd.
+	// TODO Refactor to make filepath vs URL path and relative vs absolute
+	// values clearer.
+	if _, err := os.Stat(filepath.Join(string(p.root), entrypoint)); err != nil {
+		errs.add(name, entrypoint, true, fmt.Errorf("entrypoint does not exist"))
+		return nil, errs
+	}
+
 	pushManifestPath := filepath.Join(buildDir, "push-manifest.json")
 	pushManifest, err := ReadManifest(pushManifestPath)
 	if err != nil {
-		// return err
+		errs.add(name, pushManifestPath, false, err)
 	}
 
 	var template Template
+	files := make(map[string]*file)
 
 	err = filepath.Walk(buildDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		if info.IsDir() {
 			return nil
 		}
@@ -116,82 +188,278 @@ func newBuild(config *ProjectConfig, configOrder int, name string, requirements
 			modTime: info.ModTime(),
 		}
 
-		filename, _ := filepath.Rel(string(root), path)
+		filename, _ := filepath.Rel(string(p.root), path)
+
+		// hash every file now so the ETag is cached before it's requested
+		if p.etagCache != nil {
+			p.fileETag(p.root, "/"+filepath.ToSlash(filename), info)
+		}
+
 		if filename == entrypoint {
-			f, err := root.Open(filename)
+			f, err := p.root.Open(filepath.ToSlash(filename))
 			if err != nil {
 				return err
 			}
+			defer f.Close()
 
 			data, err := ioutil.ReadAll(f)
 			if err != nil {
 				return err
 			}
 
-			template = createTemplate(entrypoint, data, info.ModTime())
+			if p.version != "" && name != "" {
+				data = rewriteBase(data, name, p.version)
+			}
+
+			if template, err = p.createTemplate(entrypoint, data, info.ModTime()); err != nil {
+				return fmt.Errorf("%s: %v", filename, err)
+			}
+
+			// compress the entrypoint now so there is no compression cost
+			// when it is served, unless transforms will change the output.
+			// Precompressed siblings, which may be smaller, can only be used
+			// if the entrypoint is served without being modified.
+			if t, ok := template.(*defaultTemplate); ok && len(p.transforms) == 0 {
+				t.variants = compressVariants(data)
+				if p.version == "" || name == "" {
+					variants, err := readVariants(path, info.ModTime())
+					if err != nil {
+						errs.add(name, path, false, err)
+					}
+					t.variants = mergeVariants(t.variants, variants)
+				}
+			}
+			if len(p.transforms) > 0 {
+				template = &transformTemplate{
+					path:       entrypoint,
+					next:       template,
+					transforms: p.transforms,
+				}
+			}
 
 			file.data = data
-			files[filename] = file
+			file.etag = contentETag(data)
+			files[filepath.ToSlash(filename)] = file
 		}
 
 		return nil
 	})
 	if err != nil {
-		// return err
+		errs.add(name, buildDir, true, err)
+		return nil, errs
+	}
+	if template == nil {
+		errs.add(name, entrypoint, true, fmt.Errorf("entrypoint could not be loaded"))
+		return nil, errs
+	}
+
+	var notFoundPage []byte
+	if p.notFoundPage != "" {
+		filename := filepath.Join(buildDir, filepath.FromSlash(p.notFoundPage))
+		if notFoundPage, err = ioutil.ReadFile(filename); err != nil {
+			errs.add(name, filename, false, err)
+		}
+	}
+
+	// resolve the manifest paths to the urls they are served from,
+	// dropping resources outside of the builds that would be a 404
+	manifest := Manifest{}
+	unservable := map[string]struct{}{}
+	for key, assets := range pushManifest {
+		resolved := make(Assets, len(assets))
+		for assetPath, asset := range assets {
+			url := resolveURL(name, p.version, assetPath)
+			if !p.servable(config, url) {
+				if _, found := unservable[url]; !found {
+					unservable[url] = struct{}{}
+					errs.add(name, url, false, fmt.Errorf("push manifest resource is not served; skipping"))
+				}
+				continue
+			}
+			if existing, found := resolved[url]; found && existing.order < asset.order {
+				continue
+			}
+			resolved[url] = asset
+		}
+		manifest[resolveURL(name, p.version, key)] = resolved
+	}
+
+	// resources preloaded for every route, which must exist
+	if preload == nil {
+		if _, err := os.Stat(filepath.Join(buildDir, defaultPreload)); err == nil {
+			preload = []string{defaultPreload}
+		}
+	}
+	preloadURLs := make([]string, 0, len(preload))
+	for _, filename := range preload {
+		filePath := filepath.Join(buildDir, filepath.FromSlash(filename))
+		if strings.HasPrefix(filename, "/") {
+			filePath = filepath.Join(string(p.root), filepath.FromSlash(filename))
+		}
+		if _, err := os.Stat(filePath); err != nil {
+			errs.add(name, filePath, false, fmt.Errorf("preload resource does not exist"))
+			continue
+		}
+		url := resolveURL(name, p.version, filename)
+		if !p.servable(config, url) {
+			errs.add(name, url, false, fmt.Errorf("preload resource is not served"))
+			continue
+		}
+		preloadURLs = append(preloadURLs, url)
 	}
 
 	// create map of routes -> push headers
 	pushHeaders := PushHeaders{}
-	prefix := name + "/"
+	shell := ""
+	if config != nil {
+		shell = config.Shell
+	}
 
-	for path, fragment := range routes {
+	for pattern, fragment := range routes {
 		set := map[string]struct{}{}
-		headers := []string{
-			fmt.Sprintf("<%s%s>; rel=preload; as=%s", prefix, "bower_components/webcomponentsjs/webcomponents-loader.js", "script"),
-			fmt.Sprintf("<%s%s>; rel=preload; as=%s", prefix, config.Shell, "document"),
-		}
-		set[headers[0]] = struct{}{}
-		set[headers[1]] = struct{}{}
-		for path, asset := range pushManifest[config.Shell] {
-			link := fmt.Sprintf("<%s%s>; rel=preload; as=%s", prefix, path, asset.Type)
-			if _, found := set[link]; !found {
-				set[link] = struct{}{}
-				headers = append(headers, link)
+		resources := []*pushResource{}
+		add := func(url, as string) {
+			if _, found := set[url]; !found {
+				set[url] = struct{}{}
+				resources = append(resources, newPushResource(url, as))
 			}
 		}
-
-		headers = append(headers, fmt.Sprintf("<%s%s>; rel=preload; as=%s", prefix, fragment, "document"))
-		for path, asset := range pushManifest[fragment] {
-			link := fmt.Sprintf("<%s%s>; rel=preload; as=%s", prefix, path, asset.Type)
-			if _, found := set[link]; !found {
-				set[link] = struct{}{}
-				headers = append(headers, link)
+		addDocument := func(filename string) {
+			if filename == "" {
+				return
+			}
+			url := resolveURL(name, p.version, filename)
+			add(url, "document")
+			assets := manifest[url]
+			for _, assetURL := range assets.sorted() {
+				add(assetURL, assets[assetURL].Type)
 			}
 		}
 
-		pushHeaders[path] = headers
+		for _, url := range preloadURLs {
+			add(url, preloadType(url))
+		}
+		addDocument(shell)
+		addDocument(fragment)
+
+		pushHeaders[pattern] = p.limitPreload(resources)
 	}
 
-	// update paths to account for the build folder name
-	manifest := Manifest{}
-	for path, assets := range pushManifest {
-		adjusted := make(map[string]AssetOpt, len(assets))
-		for assetPath, asset := range assets {
-			adjusted[prefix+assetPath] = asset
+	// create map of push manifest entries -> push headers
+	documentHeaders := PushHeaders{}
+	for url, assets := range manifest {
+		resources := make([]*pushResource, len(assets))
+		for i, assetURL := range assets.sorted() {
+			resources[i] = newPushResource(assetURL, assets[assetURL].Type)
 		}
-		manifest[prefix+path] = adjusted
+		documentHeaders[url] = p.limitPreload(resources)
 	}
 
 	build := build{
-		name:         name,
-		configOrder:  configOrder,
-		requirements: requirements,
-		entrypoint:   entrypoint,
-		template:     template,
-		pushHeaders:  pushHeaders,
+		name:            name,
+		configOrder:     configOrder,
+		requirements:    requirements,
+		entrypoint:      entrypoint,
+		template:        template,
+		pushHeaders:     pushHeaders,
+		documentHeaders: documentHeaders,
+		notFoundPage:    notFoundPage,
+		files:           files,
 	}
 
-	return &build
+	return &build, errs
+}
+
+// resolveURL resolves a push manifest path to the url it is served
+// from. Relative paths are relative to the build directory and absolute
+// paths are relative to the server root, with the version inserted for
+// paths within the build to match the url the build is served from.
+func resolveURL(name, version, filename string) string {
+	if !strings.HasPrefix(filename, "/") {
+		filename = path.Join("/", name, filename)
+	}
+	filename = path.Clean(filename)
+	if name != "" && version != "" && strings.HasPrefix(filename, "/"+name+"/") {
+		return "/" + version + filename
+	}
+	return filename
+}
+
+// servable is true if the url is served from one of the builds, or
+// is an existing static file. Without named builds the root is served.
+func (p *prpl) servable(config *ProjectConfig, url string) bool {
+	if config == nil || len(config.Builds) == 0 {
+		return true
+	}
+
+	prefix := ""
+	if p.version != "" {
+		prefix = "/" + p.version
+	}
+	for _, build := range config.Builds {
+		if build.Name != "" && strings.HasPrefix(url, prefix+"/"+build.Name+"/") {
+			return true
+		}
+	}
+
+	s := p.staticFiles
+	if s == nil || !s.allowed(url) {
+		return false
+	}
+	f, err := s.Root.Open(url)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
+
+// limitPreload truncates the resources to the preload limit
+func (p *prpl) limitPreload(resources []*pushResource) []*pushResource {
+	if p.preloadLimit > 0 && len(resources) > p.preloadLimit {
+		return resources[:p.preloadLimit]
+	}
+	return resources
+}
+
+// preloadType returns the preload destination for a resource
+func preloadType(filename string) string {
+	switch path.Ext(filename) {
+	case ".js", ".mjs":
+		return "script"
+	case ".css":
+		return "style"
+	case ".html":
+		return "document"
+	case ".woff", ".woff2", ".ttf", ".otf":
+		return "font"
+	case ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp":
+		return "image"
+	}
+	return "fetch"
+}
+
+// rewriteBase inserts the version into the entrypoint's
+// base href, e.g. <base href="/build/"> to /version/build/
+func rewriteBase(data []byte, name, version string) []byte {
+	re := regexp.MustCompile(`(<base\s[^>]*href=["'])/?` + regexp.QuoteMeta(name) + `/`)
+	return re.ReplaceAll(data, []byte("${1}/"+version+"/"+name+"/"))
+}
+
+// newPushResource creates the push resource for an absolute url
+func newPushResource(url, as string) *pushResource {
+	header := http.Header{}
+	header.Set("Cache-Control", cacheControl(url))
+	if contentType := mime.TypeByExtension(path.Ext(url)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	return &pushResource{
+		url:    url,
+		link:   fmt.Sprintf("<%s>; rel=preload; as=%s", url, as),
+		header: header,
+	}
 }
 
 func (b *build) canServe(client capability) bool {
@@ -0,0 +1,87 @@
+package prpl
+
+import (
+	"container/list"
+	"sync"
+)
+
+type (
+	// lruCache is a bounded, concurrency-safe least
+	// recently used cache keyed by string
+	lruCache struct {
+		sync.Mutex
+		capacity int
+		items    map[string]*list.Element
+		order    *list.List
+		hits     uint64
+		misses   uint64
+	}
+
+	lruEntry struct {
+		key   string
+		value interface{}
+	}
+
+	// CacheStats reports the usage of a cache
+	CacheStats struct {
+		Hits     uint64 `json:"hits"`
+		Misses   uint64 `json:"misses"`
+		Size     int    `json:"size"`
+		Capacity int    `json:"capacity"`
+	}
+)
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		items:    make(map[string]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
+
+// get returns the cached value for key, marking it as recently used
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, found := c.items[key]; found {
+		c.hits++
+		c.order.MoveToFront(e)
+		return e.Value.(*lruEntry).value, true
+	}
+
+	c.misses++
+	return nil, false
+}
+
+// add stores the value for key, evicting the least
+// recently used entry if the cache is full
+func (c *lruCache) add(key string, value interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, found := c.items[key]; found {
+		c.order.MoveToFront(e)
+		e.Value.(*lruEntry).value = value
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lruCache) stats() CacheStats {
+	c.Lock()
+	defer c.Unlock()
+
+	return CacheStats{
+		Hits:     c.hits,
+		Misses:   c.misses,
+		Size:     c.order.Len(),
+		Capacity: c.capacity,
+	}
+}
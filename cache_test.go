@@ -0,0 +1,93 @@
+package prpl
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"net/http"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	c.add("a", 1)
+	c.add("b", 2)
+
+	// reading a makes b the least recently used
+	if v, found := c.get("a"); !found || v.(int) != 1 {
+		t.Errorf("expected a to be 1: got %v", v)
+	}
+
+	c.add("c", 3)
+	if _, found := c.get("b"); found {
+		t.Error("expected b to be evicted")
+	}
+	if _, found := c.get("c"); !found {
+		t.Error("expected c to be cached")
+	}
+
+	stats := c.stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Size != 2 || stats.Capacity != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestLRUCacheConcurrent(t *testing.T) {
+	c := newLRUCache(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := strconv.Itoa((i + j) % 20)
+				if _, found := c.get(key); !found {
+					c.add(key, j)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if stats := c.stats(); stats.Size > 10 || stats.Hits+stats.Misses != 800 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCapabilityCache(t *testing.T) {
+	chrome := "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.96 Safari/537.36"
+	request := func(userAgent, hint string) *http.Request {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("User-Agent", userAgent)
+		if hint != "" {
+			r.Header.Set("Sec-CH-UA", hint)
+		}
+		return r
+	}
+
+	p := newTestServer(t)
+
+	for _, r := range []*http.Request{
+		request(chrome, ""),
+		request(chrome, ""),
+		request(chrome, `"Google Chrome";v="58"`),
+		request("unknown browser", ""),
+		request(chrome, `"Google Chrome";v="58"`),
+	} {
+		if capabilities, expect := p.detectCapabilities(r), p.detectors.detect(r); capabilities != expect {
+			t.Errorf("expected %s: got %s", expect, capabilities)
+		}
+	}
+
+	if stats := p.CapabilityCacheStats(); stats.Hits != 2 || stats.Misses != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	p = newTestServer(t, WithCapabilityCache(0))
+	if capabilities := p.detectCapabilities(request(chrome, "")); capabilities != es2015+push+serviceworker {
+		t.Errorf("expected %s: got %s", es2015+push+serviceworker, capabilities)
+	}
+	if stats := p.CapabilityCacheStats(); stats != (CacheStats{}) {
+		t.Errorf("expected disabled cache to have no stats: got %+v", stats)
+	}
+}
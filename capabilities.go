@@ -1,152 +1,233 @@
 package prpl
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+
+	"math/bits"
 
 	"github.com/ua-parser/uap-go/uaparser"
 )
 
 type (
-	userAgentPredicate func(client *uaparser.Client) bool
+	// Predicate determines if a parsed client supports a capability
+	Predicate func(client *uaparser.Client) bool
+
+	// BrowserPredicates maps a uaparser browser family, such
+	// as "Chrome" or "Mobile Safari", to the predicate for it.
+	// Families that are not included never have the capability.
+	BrowserPredicates map[string]Predicate
 
-	userAgentCapabilities struct {
-		es2015        userAgentPredicate
-		push          userAgentPredicate
-		serviceworker userAgentPredicate
+	// capabilityRegistry holds the named capabilities in the
+	// order they were registered, which is also their bit order
+	capabilityRegistry struct {
+		sync.RWMutex
+		list       []string
+		byName     map[string]capability
+		predicates capabilityMatrix
 	}
 )
 
-var browserPredicates = map[string]userAgentCapabilities{
+var browserPredicates = map[string]map[string]Predicate{
 	"Chrome": {
-		es2015:        since(49),
-		push:          since(41),
-		serviceworker: since(45),
+		"es2015":        Since(49),
+		"push":          Since(41),
+		"serviceworker": Since(45),
+		"modules":       Since(61),
 	},
 	"Chromium": {
-		es2015:        since(49),
-		push:          since(41),
-		serviceworker: since(45),
+		"es2015":        Since(49),
+		"push":          Since(41),
+		"serviceworker": Since(45),
+		"modules":       Since(61),
 	},
 	"OPR": {
-		es2015:        since(36),
-		push:          since(28),
-		serviceworker: since(32),
+		"es2015":        Since(36),
+		"push":          Since(28),
+		"serviceworker": Since(32),
+		"modules":       Since(48),
 	},
 	"Vivaldi": {
-		es2015:        since(1),
-		push:          since(1),
-		serviceworker: since(1),
+		"es2015":        Since(1),
+		"push":          Since(1),
+		"serviceworker": Since(1),
+		"modules":       Since(2),
 	},
 	"Mobile Safari": {
-		es2015:        since(10),
-		push:          since(9, 2),
-		serviceworker: notyet,
+		"es2015":        Since(10),
+		"push":          Since(9, 2),
+		"serviceworker": notyet,
+		"modules":       Since(11),
 	},
 	"Safari": {
-		es2015: since(10),
-		push: func(client *uaparser.Client) bool {
+		"es2015": Since(10),
+		"push": func(client *uaparser.Client) bool {
 			return versionAtLeast(parseVersion(client.UserAgent), 9) &&
 				// HTTP/2 on desktop Safari requires macOS 10.11 according to
 				// caniuse.com.
 				versionAtLeast(parseVersion(client.Os), 10, 11)
 		},
 		// https://webkit.org/status/#specification-service-workers
-		serviceworker: notyet,
+		"serviceworker": notyet,
+		"modules":       Since(11),
 	},
 	"Edge": {
 		// Edge versions before 15.15063 may contain a JIT bug affecting ES6
 		// constructors (https://github.com/Microsoft/ChakraCore/issues/1496).
-		es2015: since(15, 15063),
-		push:   since(12),
+		"es2015": Since(15, 15063),
+		"push":   Since(12),
 		// https://developer.microsoft.com/en-us/microsoft-edge/platform/status/serviceworker/
-		serviceworker: notyet,
+		"serviceworker": notyet,
+		"modules":       Since(16),
 	},
 	"Firefox": {
-		es2015:        since(51),
-		push:          since(36),
-		serviceworker: since(44),
+		"es2015":        Since(51),
+		"push":          Since(36),
+		"serviceworker": Since(44),
+		"modules":       Since(60),
 	},
 }
 
-type capability int
+type capability uint64
+
+// maxCapabilities is the number of capabilities that fit in the bitset
+const maxCapabilities = 64
 
+// the inbuilt capabilities are registered in this order
 const (
 	es2015 capability = 1 << iota
 	push
 	serviceworker
+	modules
 )
 
-func newCapabilities(browserCapabilities []string) capability {
-	var capability capability
-	for _, c := range browserCapabilities {
-		switch c {
-		case "es2015":
-			capability += es2015
-		case "push":
-			capability += push
-		case "serviceworker":
-			capability += serviceworker
+var registry = &capabilityRegistry{
+	byName:     make(map[string]capability),
+	predicates: make(capabilityMatrix),
+}
+
+func init() {
+	for _, name := range []string{"es2015", "push", "serviceworker", "modules"} {
+		predicates := BrowserPredicates{}
+		for family, capabilities := range browserPredicates {
+			if predicate, ok := capabilities[name]; ok {
+				predicates[family] = predicate
+			}
+		}
+		if err := RegisterCapability(name, predicates); err != nil {
+			panic(err)
 		}
 	}
-	return capability
 }
 
-func (c capability) size() int {
-	size := 0
-	if c&es2015 == es2015 {
-		size++
-	}
-	if c&push == push {
-		size++
-	}
-	if c&serviceworker == serviceworker {
-		size++
-	}
-	return size
+// RegisterCapability defines a new named capability that can be
+// used in the browserCapabilities of a build. The predicates
+// determine which browser families and versions support it.
+// It should be called before any prpl instance is created.
+func RegisterCapability(name string, predicates BrowserPredicates) error {
+	return registry.register(name, predicates)
 }
 
-func (c capability) String() string {
-	val := []string{}
-	if c&es2015 == es2015 {
-		val = append(val, "es2015")
+func (r *capabilityRegistry) register(name string, predicates BrowserPredicates) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if name == "" {
+		return fmt.Errorf("capability name is required")
 	}
-	if c&push == push {
-		val = append(val, "push")
+	if _, found := r.byName[name]; found {
+		return fmt.Errorf("capability %q is already registered", name)
 	}
-	if c&serviceworker == serviceworker {
-		val = append(val, "serviceworker")
+	if len(r.list) == maxCapabilities {
+		return fmt.Errorf("capability %q exceeds the limit of %d capabilities", name, maxCapabilities)
 	}
-	return strings.Join(val, ", ")
+
+	c := capability(1) << uint(len(r.list))
+	r.list = append(r.list, name)
+	r.byName[name] = c
+
+	for family, predicate := range predicates {
+		if _, ok := r.predicates[family]; !ok {
+			r.predicates[family] = make(map[capability]Predicate)
+		}
+		r.predicates[family][c] = predicate
+	}
+
+	return nil
+}
+
+func (r *capabilityRegistry) lookup(name string) (capability, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	c, ok := r.byName[name]
+	return c, ok
+}
+
+func (r *capabilityRegistry) evaluate(client *uaparser.Client) capability {
+	r.RLock()
+	defer r.RUnlock()
+	return r.predicates.evaluate(client)
 }
 
-func (p *prpl) browserCapabilities(userAgentString string) capability {
-	client := p.parser.Parse(userAgentString)
+func (r *capabilityRegistry) names(c capability) []string {
+	r.RLock()
+	defer r.RUnlock()
 
-	predicate, ok := browserPredicates[client.UserAgent.Family]
-	if !ok {
-		return 0
+	val := []string{}
+	for i, name := range r.list {
+		if bit := capability(1) << uint(i); c&bit == bit {
+			val = append(val, name)
+		}
 	}
+	return val
+}
 
+func newCapabilities(browserCapabilities []string) (capability, error) {
 	var capabilities capability
-	if predicate.es2015(client) {
-		capabilities += es2015
-	}
-	if predicate.push(client) {
-		capabilities += push
+	for _, name := range browserCapabilities {
+		c, ok := capabilityByName(name)
+		if !ok {
+			return 0, fmt.Errorf("unknown browser capability %q", name)
+		}
+		capabilities |= c
 	}
-	if predicate.serviceworker(client) {
-		capabilities += serviceworker
+	return capabilities, nil
+}
+
+func (c capability) size() int {
+	return bits.OnesCount64(uint64(c))
+}
+
+func (c capability) names() []string {
+	return registry.names(c)
+}
+
+func (c capability) String() string {
+	return strings.Join(c.names(), ", ")
+}
+
+// capabilities evaluates the client against the matrix, or
+// the registered predicates if no matrix has been set
+func (m capabilityMatrix) capabilities(client *uaparser.Client) capability {
+	if m == nil {
+		return registry.evaluate(client)
 	}
+	return m.evaluate(client)
+}
 
-	return capabilities
+func capabilityByName(name string) (capability, bool) {
+	return registry.lookup(name)
 }
 
 var notyet = func(client *uaparser.Client) bool {
 	return false
 }
 
-func since(atLeast ...int) userAgentPredicate {
+// Since creates a predicate that is true when the browser
+// version is at least the version given, e.g. Since(15, 15063)
+func Since(atLeast ...int) Predicate {
 	return func(client *uaparser.Client) bool {
 		version := parseVersion(client.UserAgent)
 		return versionAtLeast(version, atLeast...)
@@ -3,6 +3,8 @@ package prpl
 import (
 	"testing"
 
+	"net/http"
+
 	"github.com/ua-parser/uap-go/uaparser"
 )
 
@@ -13,11 +15,7 @@ func assertBrowserCapabilities(userAgent string, expect capability) {
 }
 
 func TestCapabilities(t *testing.T) {
-	p, err := New()
-	if err != nil {
-		t.Error(err.Error())
-		return
-	}
+	p := newTestServer(t)
 
 	tests := []struct {
 		userAgent    string
@@ -99,3 +97,87 @@ func TestVersionAtLeast(t *testing.T) {
 func ints(ints ...int) []int {
 	return ints
 }
+
+func TestRegisterCapability(t *testing.T) {
+	if err := RegisterCapability("es2015", BrowserPredicates{}); err == nil {
+		t.Error("expected error registering duplicate capability")
+	}
+
+	if err := RegisterCapability("test-es2017", BrowserPredicates{
+		"Test Browser": Since(3),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer registry.unregister("test-es2017")
+
+	es2017, ok := capabilityByName("test-es2017")
+	if !ok {
+		t.Fatal("expected registered capability to be found")
+	}
+
+	requirements, err := newCapabilities([]string{"es2015", "test-es2017"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requirements.size() != 2 {
+		t.Errorf("expected size 2: got %d", requirements.size())
+	}
+	if expected := "es2015, test-es2017"; requirements.String() != expected {
+		t.Errorf("expected %q: got %q", expected, requirements.String())
+	}
+
+	for version, expect := range map[string]capability{"2": 0, "3": es2017} {
+		client := &uaparser.Client{
+			UserAgent: &uaparser.UserAgent{Family: "Test Browser", Major: version},
+			Os:        &uaparser.Os{},
+			Device:    &uaparser.Device{},
+		}
+		if capabilities := registry.evaluate(client); capabilities != expect {
+			t.Errorf("expected version %s to have %s: got %s", version, expect, capabilities)
+		}
+	}
+
+	if _, err := newCapabilities([]string{"es2015", "unknown"}); err == nil {
+		t.Error("expected error for unknown capability")
+	}
+
+	if _, err := New(WithRoot(http.Dir("testdata/app")), WithConfig(&ProjectConfig{
+		Builds: []BuildConfig{{Name: "modern", BrowserCapabilities: []string{"unknown"}}},
+	})); err == nil {
+		t.Error("expected error for build with unknown capability")
+	}
+
+	registry.unregister("test-es2017")
+	if _, ok := capabilityByName("test-es2017"); ok {
+		t.Error("expected unregistered capability not to be found")
+	}
+	if err := RegisterCapability("test-es2017", BrowserPredicates{}); err != nil {
+		t.Errorf("expected capability to be registered again: %v", err)
+	}
+}
+
+// browserCapabilities evaluates the user agent alone, without
+// the other detectors
+func (p *prpl) browserCapabilities(userAgentString string) capability {
+	return p.matrix.capabilities(p.parser.Parse(userAgentString))
+}
+
+// unregister removes the capability if it was the last to be
+// registered, so that the bits of the others are unchanged
+func (r *capabilityRegistry) unregister(name string) {
+	r.Lock()
+	defer r.Unlock()
+
+	c, found := r.byName[name]
+	if !found || r.list[len(r.list)-1] != name {
+		return
+	}
+	r.list = r.list[:len(r.list)-1]
+	delete(r.byName, name)
+	for family, predicates := range r.predicates {
+		delete(predicates, c)
+		if len(predicates) == 0 {
+			delete(r.predicates, family)
+		}
+	}
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/captaincodeman/prpl-server-go"
@@ -19,18 +22,32 @@ var (
 	port         int
 	root         string
 	config       string
+	routes       string
+	fragments    bool
+	preloadLimit int
+	earlyHints   bool
+	notFoundPage string
+	static       string
 	httpRedirect bool
+	strict       bool
+	watch        time.Duration
 )
 
 func init() {
-	timestamp := time.Now().Format("20060102150405")
-
 	flag.BoolVar(&help, "help", false, "Print this help text.")
 	flag.BoolVar(&version, "version", false, "Print the installed version.")
 	flag.StringVar(&host, "host", "127.0.0.1", "Listen on this hostname (default 127.0.0.1).")
 	flag.IntVar(&port, "port", 8080, "Listen on this port; 0 for random (default 8080).")
 	flag.StringVar(&root, "root", ".", `Serve files relative to this directory (default ".").`)
 	flag.StringVar(&config, "config", "", `JSON configuration file (default "<root>/polymer.json" if exists).`)
+	flag.StringVar(&routes, "routes", "", "JSON file mapping url patterns to fragments for preload headers, e.g. {\"/view1\": \"src/my-view1.html\"}.")
+	flag.BoolVar(&fragments, "fragment-routes", false, `Derive a route for each fragment in the config, e.g. "src/my-view1.html" is routed from "/view1".`)
+	flag.IntVar(&preloadLimit, "preload-limit", 0, "Maximum number of resources to push or preload for each route (default no limit).")
+	flag.BoolVar(&earlyHints, "early-hints", false, "Send a 103 Early Hints response with the preload links before each entrypoint.")
+	flag.StringVar(&notFoundPage, "not-found-page", "", `Page in each build directory to serve for files that are not found, e.g. "404.html".`)
+	flag.StringVar(&static, "static", "", `Comma separated globs of files in the root to serve from outside the builds, e.g. "robots.txt,manifest.json,/images/*" (default none).`)
+	flag.BoolVar(&strict, "strict", false, "Fail to start if any build has a problem such as a missing push manifest.")
+	flag.DurationVar(&watch, "watch", 0, "Poll for changes to the builds at this interval and reload them, e.g. 2s (default disabled).")
 	flag.BoolVar(&httpRedirect, "http-redirect", false, "Redirect HTTP requests to HTTPS with a 301. Assumes same hostname and default port (443). Trusts X-Forwarded-* headers for detecting protocol and hostname.")
 }
 
@@ -62,17 +79,32 @@ func main() {
 		return
 	}
 
-	m, _ := prpl.New(
+	if config == "" {
+		config = filepath.Join(root, "polymer.json")
+	}
+
+	var staticFiles *prpl.StaticFiles
+	if static != "" {
+		staticFiles = &prpl.StaticFiles{Allow: strings.Split(static, ",")}
+	}
+
+	m, err := prpl.New(
 		prpl.WithRoot(http.Dir(root)),
 		prpl.WithConfigFile(config),
+		prpl.WithRoutesFile(routes),
+		prpl.WithFragmentRoutes(fragments),
+		prpl.WithPreloadLimit(preloadLimit),
+		prpl.WithEarlyHints(earlyHints),
+		prpl.WithNotFoundPage(notFoundPage),
+		prpl.WithStaticFiles(staticFiles),
+		prpl.WithStrict(strict),
+		prpl.WithWatch(watch),
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	var h http.Handler
-
-	h = m
-	h = middleware.Recoverer(h)
-	h = middleware.Logger(h)
-	h = middleware.DefaultCompress(h)
+	h := handler(m)
 
 	// TODO: graceful shutdown
 	// TODO: redirect to https (auto cert?)
@@ -81,3 +113,14 @@ func main() {
 	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	http.ListenAndServe(addr, h)
 }
+
+// handler wraps the prpl handler with the middleware
+func handler(m http.Handler) http.Handler {
+	var h http.Handler
+
+	h = m
+	h = middleware.Recoverer(h)
+	h = middleware.Logger(h)
+
+	return h
+}
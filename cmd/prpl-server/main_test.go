@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+
+	"github.com/captaincodeman/prpl-server-go"
+)
+
+func TestEarlyHintsMiddleware(t *testing.T) {
+	m, err := prpl.New(
+		prpl.WithRoot(http.Dir("../../testdata/app")),
+		prpl.WithConfigFile("../../testdata/app/polymer.json"),
+		prpl.WithRoutes(prpl.Routes{"/view1": "src/my-view1.html"}),
+		prpl.WithEarlyHints(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(handler(m))
+	defer s.Close()
+
+	get := func(etag string) (*http.Response, int) {
+		hints := 0
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				if code == http.StatusEarlyHints && len(header["Link"]) > 0 {
+					hints++
+				}
+				return nil
+			},
+		}
+
+		r, _ := http.NewRequest("GET", s.URL+"/view1", nil)
+		r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Body.Close()
+		return w, hints
+	}
+
+	w, hints := get("")
+	if w.StatusCode != http.StatusOK || hints != 1 {
+		t.Errorf("expected status 200 with early hints: got %d with %d", w.StatusCode, hints)
+	}
+
+	// the final status is not replaced by the middleware
+	w, hints = get(w.Header.Get("ETag"))
+	if w.StatusCode != http.StatusNotModified || hints != 1 {
+		t.Errorf("expected status 304 with early hints: got %d with %d", w.StatusCode, hints)
+	}
+}
@@ -0,0 +1,253 @@
+package prpl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/andybalholm/brotli"
+)
+
+type (
+	// encoding is a content coding that precompressed
+	// siblings of a file can be served with
+	encoding struct {
+		name string
+		ext  string
+	}
+
+	// variant is a precompressed copy of the content
+	variant struct {
+		encoding string
+		data     []byte
+		etag     string
+	}
+)
+
+// encodings are the supported content codings in order of preference
+var encodings = []encoding{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// acceptsEncoding is true if the Accept-Encoding header
+// allows the content coding with a non-zero quality
+func acceptsEncoding(header, coding string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		name, q := part, 1.0
+		if i := strings.Index(part, ";"); i != -1 {
+			name = part[:i]
+			param := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case coding:
+			return q > 0
+		case "*":
+			accepted = q > 0
+		}
+	}
+	return accepted
+}
+
+// negotiateVariant returns the preferred variant the client accepts, or
+// nil if the content should be served without a content coding
+func negotiateVariant(r *http.Request, variants []*variant) *variant {
+	header := r.Header.Get("Accept-Encoding")
+	if header == "" {
+		return nil
+	}
+	for _, v := range variants {
+		if acceptsEncoding(header, v.encoding) {
+			return v
+		}
+	}
+	return nil
+}
+
+// serveFile serves the file from the root, or its preferred
+// precompressed sibling, returning false if it doesn't exist
+func (p *prpl) serveFile(w http.ResponseWriter, r *http.Request, root http.Dir, name string) bool {
+	f, err := root.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	if p.servePrecompressed(w, r, root, name, info.ModTime()) {
+		return true
+	}
+
+	w.Header().Set("ETag", p.fileETag(root, name, info))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+	return true
+}
+
+// servePrecompressed serves the preferred precompressed sibling of the
+// file, such as app.js.br for app.js, if the client accepts it. Siblings
+// older than the file are skipped as they may not match its content.
+// It sets Vary if the file has siblings and returns false if none was served.
+func (p *prpl) servePrecompressed(w http.ResponseWriter, r *http.Request, root http.Dir, name string, modTime time.Time) bool {
+	header := r.Header.Get("Accept-Encoding")
+	found := false
+	for _, enc := range encodings {
+		f, err := root.Open(name + enc.ext)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().Before(modTime) {
+			continue
+		}
+		if !found {
+			w.Header().Add("Vary", "Accept-Encoding")
+			found = true
+		}
+		if !acceptsEncoding(header, enc.name) {
+			continue
+		}
+
+		h := w.Header()
+		h.Set("Content-Encoding", enc.name)
+		h.Set("ETag", p.fileETag(root, name+enc.ext, info))
+		http.ServeContent(w, r, name, info.ModTime(), f)
+		return true
+	}
+	return false
+}
+
+// compressVariants compresses the content with each of the encodings,
+// keeping the variants that are smaller than the content
+func compressVariants(data []byte) []*variant {
+	variants := []*variant{}
+	for _, enc := range encodings {
+		if v := compressVariant(enc.name, data, true); v != nil {
+			variants = append(variants, v)
+		}
+	}
+	return variants
+}
+
+// compressResponse compresses content rendered for a request with the
+// preferred encoding the client accepts, using the default level as it
+// is done per request. It returns nil if the content isn't compressed.
+func compressResponse(r *http.Request, data []byte) *variant {
+	header := r.Header.Get("Accept-Encoding")
+	if header == "" {
+		return nil
+	}
+	for _, enc := range encodings {
+		if acceptsEncoding(header, enc.name) {
+			return compressVariant(enc.name, data, false)
+		}
+	}
+	return nil
+}
+
+// compressVariant compresses the content with the encoding, returning
+// nil if the compressed content is not smaller
+func compressVariant(encoding string, data []byte, best bool) *variant {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "br":
+		level := brotli.DefaultCompression
+		if best {
+			level = brotli.BestCompression
+		}
+		w = brotli.NewWriterLevel(&buf, level)
+	case "gzip":
+		level := gzip.DefaultCompression
+		if best {
+			level = gzip.BestCompression
+		}
+		w, _ = gzip.NewWriterLevel(&buf, level)
+	}
+	w.Write(data)
+	w.Close()
+
+	if buf.Len() >= len(data) {
+		return nil
+	}
+	return &variant{
+		encoding: encoding,
+		data:     buf.Bytes(),
+		etag:     contentETag(buf.Bytes()),
+	}
+}
+
+// mergeVariants replaces the variants with the precompressed ones
+// for the same encoding, keeping the order of the encodings
+func mergeVariants(variants, precompressed []*variant) []*variant {
+	merged := []*variant{}
+	for _, enc := range encodings {
+		var selected *variant
+		for _, v := range variants {
+			if v.encoding == enc.name {
+				selected = v
+			}
+		}
+		for _, v := range precompressed {
+			if v.encoding == enc.name {
+				selected = v
+			}
+		}
+		if selected != nil {
+			merged = append(merged, selected)
+		}
+	}
+	return merged
+}
+
+// readVariants reads the precompressed siblings of the file. Siblings
+// older than the file are skipped as they may not match its content.
+func readVariants(filename string, modTime time.Time) ([]*variant, error) {
+	variants := []*variant{}
+	stale := []string{}
+	for _, enc := range encodings {
+		info, err := os.Stat(filename + enc.ext)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(modTime) {
+			stale = append(stale, enc.ext)
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filename + enc.ext)
+		if err != nil {
+			return variants, err
+		}
+		variants = append(variants, &variant{
+			encoding: enc.name,
+			data:     data,
+			etag:     contentETag(data),
+		})
+	}
+
+	if len(stale) > 0 {
+		return variants, fmt.Errorf("precompressed %s older than the file; skipping", strings.Join(stale, ", "))
+	}
+	return variants, nil
+}
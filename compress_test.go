@@ -0,0 +1,148 @@
+package prpl
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		coding string
+		expect bool
+	}{
+		{"gzip, deflate, br", "br", true},
+		{"gzip, deflate", "br", false},
+		{"br;q=0, gzip", "br", false},
+		{"BR;q=0.5", "br", true},
+		{"*", "br", true},
+		{"*;q=0, gzip", "br", false},
+		{"br;q=0, *", "br", false},
+		{"", "gzip", false},
+	}
+
+	for _, test := range tests {
+		if accepted := acceptsEncoding(test.header, test.coding); accepted != test.expect {
+			t.Errorf("%q %s expected %t: got %t", test.header, test.coding, test.expect, accepted)
+		}
+	}
+}
+
+func TestPrecompressed(t *testing.T) {
+	dir := copyDir(t, "testdata/app")
+	defer os.RemoveAll(dir)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("// modern my-view1.js\n"))
+	zw.Close()
+
+	// siblings must be newer than the file
+	future := time.Now().Add(time.Hour)
+	for filename, data := range map[string][]byte{
+		"modern/index.html.br":      []byte("index br"),
+		"modern/index.html.gz":      []byte("index gz"),
+		"modern/src/my-view1.js.br": []byte("my-view1 br"),
+		"modern/src/my-view1.js.gz": gz.Bytes(),
+		"modern/src/my-view2.js.gz": []byte("stale"),
+		"robots.txt.gz":             []byte("robots gz"),
+		"fallback/index.html.gz":    []byte("stale"),
+	} {
+		filename = filepath.Join(dir, filename)
+		if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if string(data) == "stale" {
+			os.Chtimes(filename, time.Time{}, time.Unix(0, 0))
+		} else {
+			os.Chtimes(filename, future, future)
+		}
+	}
+
+	p, err := New(
+		WithRoot(http.Dir(dir)),
+		WithConfigFile(filepath.Join(dir, "polymer.json")),
+		WithStaticFiles(&StaticFiles{Allow: []string{"*.txt", "/shared/*"}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := p.generation().report
+	if len(report) != 1 || report[0].Build != "fallback" || report[0].Fatal {
+		t.Errorf("expected error for stale precompressed entrypoint: got %v", report)
+	}
+
+	const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/60.0.3112.113 Safari/537.36"
+
+	tests := []struct {
+		path           string
+		userAgent      string
+		acceptEncoding string
+		encoding       string
+		vary           bool
+		expect         string
+	}{
+		{"/view1", chrome, "gzip, br", "br", true, "index br"},
+		{"/view1", chrome, "gzip", "gzip", true, "index gz"},
+		{"/view1", chrome, "", "", true, "<my-app>modern</my-app>"},
+		{"/view1", "unknown browser", "", "", true, "<my-app>fallback</my-app>"},
+		{"/modern/src/my-view1.js", "", "br;q=0, gzip", "gzip", true, string(gz.Bytes())},
+		{"/modern/src/my-view1.js", "", "identity", "", true, "// modern my-view1.js\n"},
+		{"/modern/src/my-view2.js", "", "gzip, br", "", false, "// modern my-view2.js\n"}, // stale sibling
+		{"/robots.txt", "", "gzip", "gzip", true, "robots gz"},
+	}
+
+	etags := map[string]string{}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Header.Set("User-Agent", test.userAgent)
+		r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), test.expect) {
+			t.Errorf("%s %s expected %q: got %d %q", test.path, test.acceptEncoding, test.expect, w.Code, w.Body.String())
+		}
+		if encoding := w.Header().Get("Content-Encoding"); encoding != test.encoding {
+			t.Errorf("%s %s expected Content-Encoding %q: got %q", test.path, test.acceptEncoding, test.encoding, encoding)
+		}
+		if vary := strings.Contains(strings.Join(w.Header()["Vary"], ","), "Accept-Encoding"); vary != test.vary {
+			t.Errorf("%s %s expected Vary Accept-Encoding %t: got %v", test.path, test.acceptEncoding, test.vary, w.Header()["Vary"])
+		}
+		if contentType := w.Header().Get("Content-Type"); strings.HasSuffix(test.path, ".js") && !strings.Contains(contentType, "javascript") {
+			t.Errorf("%s expected javascript Content-Type: got %s", test.path, contentType)
+		}
+
+		// each variant has its own ETag
+		if test.vary {
+			etag := w.Header().Get("ETag")
+			key := test.path + " " + test.encoding
+			for other, otherETag := range etags {
+				if etag == otherETag && other != key {
+					t.Errorf("%s expected ETag different to %s: got %s", key, other, etag)
+				}
+			}
+			etags[key] = etag
+		}
+	}
+
+	// ranges are of the encoded content
+	r := httptest.NewRequest("GET", "/modern/src/my-view1.js", nil)
+	r.Header.Set("Accept-Encoding", "br")
+	r.Header.Set("Range", "bytes=0-7")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "my-view1" || w.Header().Get("Content-Encoding") != "br" {
+		t.Errorf("expected partial br content: got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+}
@@ -16,16 +16,29 @@ type (
 	ProjectConfig struct {
 		Entrypoint string        `json:"entrypoint"`
 		Shell      string        `json:"shell"`
+		Fragments  []string      `json:"fragments"`
 		Builds     []BuildConfig `json:"builds"`
+
+		// Routes is not part of the polymer.json specification
+		// but allows the routes to be configured with the builds
+		Routes Routes `json:"routes,omitempty"`
 	}
 
 	// BuildConfig contains the build-specific browser capabilities
 	BuildConfig struct {
 		Name                string   `json:"name"`
 		BrowserCapabilities []string `json:"browserCapabilities"`
+
+		// Preload is not part of the polymer.json specification. It
+		// lists the resources to preload for every route, resolved
+		// like push manifest paths. If it is not set the webcomponents
+		// loader is preloaded when it is present in the build.
+		Preload []string `json:"preload,omitempty"`
 	}
 
-	// Routes map urls to fragments
+	// Routes map url patterns to fragments. A pattern is an exact
+	// path, a path with :param segments and an optional trailing *
+	// wildcard, or a regular expression prefixed with ~
 	Routes map[string]string
 )
 
@@ -51,3 +64,22 @@ func ConfigFromReader(r io.Reader) (*ProjectConfig, error) {
 	}
 	return &config, nil
 }
+
+// RoutesFromFile loads a JSON file mapping url patterns to fragments
+func RoutesFromFile(filename string) (Routes, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return RoutesFromReader(file)
+}
+
+func RoutesFromReader(r io.Reader) (Routes, error) {
+	var routes Routes
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(&routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
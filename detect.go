@@ -0,0 +1,337 @@
+package prpl
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+
+	"net/http"
+
+	"github.com/ua-parser/uap-go/uaparser"
+)
+
+type (
+	// Detector determines the capabilities of the browser making
+	// a request. The results of all configured detectors are
+	// combined so each only needs to report what it can see.
+	Detector interface {
+		// Detect returns the names of the capabilities indicated by
+		// the request, names that aren't registered are ignored
+		Detect(r *http.Request) []string
+
+		// Headers returns the request headers the detector uses.
+		// These are listed in the Vary header of entrypoint responses
+		// and any Sec-CH-* client hints are requested with Accept-CH.
+		Headers() []string
+	}
+
+	// detectors is a chain of detectors
+	detectors []Detector
+
+	// capabilityDetector is implemented by the inbuilt detectors
+	// so their capabilities don't need to be looked up by name
+	capabilityDetector interface {
+		detect(r *http.Request) capability
+	}
+
+	// matrixDetector is implemented by detectors that evaluate
+	// browser predicates so an instance capability matrix can be
+	// used in place of the registered predicates
+	matrixDetector interface {
+		withMatrix(matrix capabilityMatrix) Detector
+	}
+
+	userAgentDetector struct {
+		parser *uaparser.Parser
+		matrix capabilityMatrix
+	}
+
+	clientHintsDetector struct {
+		matrix capabilityMatrix
+	}
+
+	acceptDetector struct {
+		rules map[string]capability
+	}
+
+	// brand is a single entry from a Sec-CH-UA brand list
+	brand struct {
+		name    string
+		version string
+	}
+)
+
+// client hint request headers
+const (
+	hintUA                = "Sec-CH-UA"
+	hintUAFullVersionList = "Sec-CH-UA-Full-Version-List"
+	hintUAPlatform        = "Sec-CH-UA-Platform"
+	hintUAPlatformVersion = "Sec-CH-UA-Platform-Version"
+)
+
+// brandFamilies maps client hint brands to uaparser families
+// so the same browser predicates can be used for both
+var brandFamilies = map[string]string{
+	"Google Chrome":  "Chrome",
+	"Chromium":       "Chromium",
+	"Microsoft Edge": "Edge",
+	"Opera":          "OPR",
+	"Vivaldi":        "Vivaldi",
+}
+
+// platformFamilies maps client hint platforms to uaparser families
+var platformFamilies = map[string]string{
+	"macOS":     "Mac OS X",
+	"Windows":   "Windows",
+	"Android":   "Android",
+	"Chrome OS": "Chrome OS",
+	"Linux":     "Linux",
+}
+
+// NewUserAgentDetector creates a detector that parses the
+// User-Agent header
+func NewUserAgentDetector(parser *uaparser.Parser) Detector {
+	return &userAgentDetector{parser: parser}
+}
+
+func (d *userAgentDetector) Detect(r *http.Request) []string {
+	return d.detect(r).names()
+}
+
+func (d *userAgentDetector) detect(r *http.Request) capability {
+	return d.matrix.capabilities(d.parser.Parse(r.UserAgent()))
+}
+
+func (d *userAgentDetector) withMatrix(matrix capabilityMatrix) Detector {
+	return &userAgentDetector{parser: d.parser, matrix: matrix}
+}
+
+func (d *userAgentDetector) Headers() []string {
+	return []string{"User-Agent"}
+}
+
+// NewClientHintsDetector creates a detector that uses the
+// User-Agent Client Hints headers which continue to provide
+// full version information when the User-Agent is reduced
+func NewClientHintsDetector() Detector {
+	return &clientHintsDetector{}
+}
+
+func (d *clientHintsDetector) Detect(r *http.Request) []string {
+	return d.detect(r).names()
+}
+
+func (d *clientHintsDetector) detect(r *http.Request) capability {
+	brands := parseBrands(r.Header.Get(hintUAFullVersionList))
+	if len(brands) == 0 {
+		brands = parseBrands(r.Header.Get(hintUA))
+	}
+
+	family, version := "", ""
+	for _, b := range brands {
+		f, ok := brandFamilies[b.name]
+		if !ok {
+			continue
+		}
+		// Chromium is listed by all chromium based browsers
+		// so only use it if there is nothing more specific
+		if family == "" || family == "Chromium" {
+			family, version = f, b.version
+		}
+	}
+	if family == "" {
+		return 0
+	}
+
+	client := &uaparser.Client{
+		UserAgent: &uaparser.UserAgent{Family: family},
+		Os:        &uaparser.Os{Family: platformFamilies[unquote(r.Header.Get(hintUAPlatform))]},
+		Device:    &uaparser.Device{},
+	}
+	client.UserAgent.Major, client.UserAgent.Minor, client.UserAgent.Patch, _ = splitVersion(version)
+	client.Os.Major, client.Os.Minor, client.Os.Patch, client.Os.PatchMinor = splitVersion(unquote(r.Header.Get(hintUAPlatformVersion)))
+
+	return d.matrix.capabilities(client)
+}
+
+func (d *clientHintsDetector) withMatrix(matrix capabilityMatrix) Detector {
+	return &clientHintsDetector{matrix: matrix}
+}
+
+func (d *clientHintsDetector) Headers() []string {
+	return []string{hintUA, hintUAFullVersionList, hintUAPlatform, hintUAPlatformVersion}
+}
+
+// NewAcceptDetector creates a detector that grants capabilities
+// when the Accept header includes a media type, e.g. a rule of
+// "image/webp": "webp" would indicate support for webp images
+func NewAcceptDetector(rules map[string]string) (Detector, error) {
+	d := &acceptDetector{rules: make(map[string]capability, len(rules))}
+	for mediaType, name := range rules {
+		c, ok := capabilityByName(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown capability %q for media type %q", name, mediaType)
+		}
+		d.rules[strings.ToLower(mediaType)] |= c
+	}
+	return d, nil
+}
+
+func (d *acceptDetector) Detect(r *http.Request) []string {
+	return d.detect(r).names()
+}
+
+func (d *acceptDetector) detect(r *http.Request) capability {
+	var capabilities capability
+	for _, accept := range r.Header["Accept"] {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(part)
+			if err != nil {
+				continue
+			}
+			if q, ok := params["q"]; ok {
+				if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+					continue
+				}
+			}
+			capabilities |= d.rules[mediaType]
+		}
+	}
+	return capabilities
+}
+
+func (d *acceptDetector) Headers() []string {
+	return []string{"Accept"}
+}
+
+// detect combines the capabilities from each detector
+func (d detectors) detect(r *http.Request) capability {
+	var capabilities capability
+	for _, detector := range d {
+		if cd, ok := detector.(capabilityDetector); ok {
+			capabilities |= cd.detect(r)
+			continue
+		}
+		for _, name := range detector.Detect(r) {
+			if c, ok := capabilityByName(name); ok {
+				capabilities |= c
+			}
+		}
+	}
+	return capabilities
+}
+
+// headers returns the request headers used by all the detectors
+func (d detectors) headers() []string {
+	set := map[string]struct{}{}
+	headers := []string{}
+	for _, detector := range d {
+		for _, header := range detector.Headers() {
+			key := http.CanonicalHeaderKey(header)
+			if _, found := set[key]; !found {
+				set[key] = struct{}{}
+				headers = append(headers, header)
+			}
+		}
+	}
+	return headers
+}
+
+// responseHeaders returns the Accept-CH and Vary header values
+// to send with entrypoint responses
+func (d detectors) responseHeaders() (acceptCH, vary string) {
+	headers := d.headers()
+	hints := []string{}
+	for _, header := range headers {
+		if strings.HasPrefix(http.CanonicalHeaderKey(header), "Sec-Ch-") {
+			hints = append(hints, header)
+		}
+	}
+	return strings.Join(hints, ", "), strings.Join(headers, ", ")
+}
+
+// detectCapabilities returns the capabilities of the browser making
+// the request, using the cached result for identical request headers
+func (p *prpl) detectCapabilities(r *http.Request) capability {
+	if p.capabilityCache == nil {
+		return p.detectors.detect(r)
+	}
+
+	values := make([]string, len(p.detectHeaders))
+	for i, header := range p.detectHeaders {
+		values[i] = strings.Join(r.Header[http.CanonicalHeaderKey(header)], ",")
+	}
+	key := strings.Join(values, "\x00")
+
+	if c, found := p.capabilityCache.get(key); found {
+		return c.(capability)
+	}
+
+	c := p.detectors.detect(r)
+	p.capabilityCache.add(key, c)
+	return c
+}
+
+// CapabilityCacheStats returns the hit and miss counts of the
+// capability detection cache
+func (p *prpl) CapabilityCacheStats() CacheStats {
+	if p.capabilityCache == nil {
+		return CacheStats{}
+	}
+	return p.capabilityCache.stats()
+}
+
+// parseBrands parses a Sec-CH-UA structured header list, e.g.
+// "Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"
+func parseBrands(value string) []brand {
+	brands := []brand{}
+	for _, item := range splitQuoted(value, ',') {
+		params := splitQuoted(item, ';')
+		if len(params) == 0 {
+			continue
+		}
+		b := brand{name: unquote(params[0])}
+		for _, param := range params[1:] {
+			if kv := strings.SplitN(param, "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == "v" {
+				b.version = unquote(kv[1])
+			}
+		}
+		if b.name != "" {
+			brands = append(brands, b)
+		}
+	}
+	return brands
+}
+
+// splitQuoted splits s on sep, ignoring separators within quotes
+func splitQuoted(s string, sep rune) []string {
+	parts := []string{}
+	quoted, start := false, 0
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		parts = append(parts, last)
+	}
+	return parts
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func splitVersion(version string) (major, minor, patch, patchMinor string) {
+	parts := append(strings.Split(version, "."), "", "", "", "")
+	return parts[0], parts[1], parts[2], parts[3]
+}
@@ -0,0 +1,124 @@
+package prpl
+
+import (
+	"strings"
+	"testing"
+
+	"net/http"
+)
+
+func TestClientHintsDetector(t *testing.T) {
+	d := NewClientHintsDetector()
+
+	tests := []struct {
+		headers      map[string]string
+		capabilities capability
+	}{
+		// no hints has no capabilities
+		{map[string]string{}, 0},
+
+		// unknown brands have no capabilities
+		{map[string]string{"Sec-CH-UA": `"Not=A?Brand";v="99"`}, 0},
+
+		// chrome brand is preferred over chromium
+		{map[string]string{"Sec-CH-UA": `"Chromium";v="58", "Google Chrome";v="58", "Not=A?Brand";v="99"`}, es2015 + push + serviceworker},
+		{map[string]string{"Sec-CH-UA": `"Chromium";v="44"`}, push},
+
+		// full version list is used in preference to the reduced brand list
+		{map[string]string{
+			"Sec-CH-UA":                   `"Microsoft Edge";v="15"`,
+			"Sec-CH-UA-Full-Version-List": `"Microsoft Edge";v="15.15063.0.0"`,
+		}, es2015 + push},
+		{map[string]string{"Sec-CH-UA": `"Microsoft Edge";v="15"`}, push},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		for key, value := range test.headers {
+			r.Header.Set(key, value)
+		}
+		if capabilities := (detectors{d}).detect(r); capabilities != test.capabilities {
+			t.Errorf("expected %v to have %s: got %s", test.headers, test.capabilities, capabilities)
+		}
+	}
+}
+
+func TestAcceptDetector(t *testing.T) {
+	if _, err := NewAcceptDetector(map[string]string{"image/webp": "unknown"}); err == nil {
+		t.Error("expected error for unknown capability")
+	}
+
+	d, err := NewAcceptDetector(map[string]string{"application/x-push": "push"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		accept       string
+		capabilities capability
+	}{
+		{"", 0},
+		{"text/html, */*;q=0.8", 0},
+		{"text/html, application/x-push", push},
+		{"text/html, application/x-push;q=0", 0},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", test.accept)
+		if capabilities := (detectors{d}).detect(r); capabilities != test.capabilities {
+			t.Errorf("expected %q to have %s: got %s", test.accept, test.capabilities, capabilities)
+		}
+	}
+}
+
+// testDetector reports the capabilities named in a request header,
+// as a detector outside of the package would
+type testDetector struct{}
+
+func (testDetector) Detect(r *http.Request) []string {
+	return strings.Split(r.Header.Get("X-Capabilities"), ",")
+}
+
+func (testDetector) Headers() []string {
+	return []string{"X-Capabilities"}
+}
+
+func TestCustomDetector(t *testing.T) {
+	d := detectors{testDetector{}, NewClientHintsDetector()}
+
+	tests := []struct {
+		headers      map[string]string
+		capabilities capability
+	}{
+		{map[string]string{}, 0},
+		{map[string]string{"X-Capabilities": "push,modules"}, push + modules},
+
+		// unregistered names are ignored
+		{map[string]string{"X-Capabilities": "push,unknown"}, push},
+
+		// combined with the other detectors
+		{map[string]string{"X-Capabilities": "modules", "Sec-CH-UA": `"Chromium";v="44"`}, push + modules},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		for key, value := range test.headers {
+			r.Header.Set(key, value)
+		}
+		if capabilities := d.detect(r); capabilities != test.capabilities {
+			t.Errorf("expected %v to have %s: got %s", test.headers, test.capabilities, capabilities)
+		}
+	}
+}
+
+func TestDetectorsResponseHeaders(t *testing.T) {
+	d := detectors{NewUserAgentDetector(nil), NewClientHintsDetector(), NewClientHintsDetector()}
+	acceptCH, vary := d.responseHeaders()
+	if expected := "Sec-CH-UA, Sec-CH-UA-Full-Version-List, Sec-CH-UA-Platform, Sec-CH-UA-Platform-Version"; acceptCH != expected {
+		t.Errorf("expected Accept-CH %q: got %q", expected, acceptCH)
+	}
+	if expected := "User-Agent, Sec-CH-UA, Sec-CH-UA-Full-Version-List, Sec-CH-UA-Platform, Sec-CH-UA-Platform-Version"; vary != expected {
+		t.Errorf("expected Vary %q: got %q", expected, vary)
+	}
+}
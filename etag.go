@@ -0,0 +1,70 @@
+package prpl
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// fileETag returns a strong ETag from the content hash of the file.
+// Hashes are cached keyed by the path, size and modification time of
+// the file, so a changed file is hashed again. If the file can't be
+// hashed a weak ETag from the size and modification time is used.
+func (p *prpl) fileETag(root http.Dir, name string, info os.FileInfo) string {
+	key := fmt.Sprintf("%s\x00%s\x00%d\x00%d", root, name, info.Size(), info.ModTime().UnixNano())
+	if p.etagCache != nil {
+		if etag, found := p.etagCache.get(key); found {
+			return etag.(string)
+		}
+	}
+
+	etag, err := hashFile(root, name)
+	if err != nil {
+		log.Printf("WARNING: hashing %s: %v\n", name, err)
+		return fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+	}
+
+	if p.etagCache != nil {
+		p.etagCache.add(key, etag)
+	}
+	return etag
+}
+
+// hashFile returns the content hash ETag of the file
+func hashFile(root http.Dir, name string) (string, error) {
+	f, err := root.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hashETag(hash.Sum(nil)), nil
+}
+
+// contentETag returns a strong ETag based on the content hash
+func contentETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hashETag(sum[:])
+}
+
+func hashETag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ETagCacheStats returns the hit and miss counts of the
+// file ETag cache
+func (p *prpl) ETagCacheStats() CacheStats {
+	if p.etagCache == nil {
+		return CacheStats{}
+	}
+	return p.etagCache.stats()
+}
@@ -0,0 +1,86 @@
+package prpl
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+)
+
+func TestFileETags(t *testing.T) {
+	dir := copyDir(t, "testdata/app")
+	defer os.RemoveAll(dir)
+
+	// instances with different modification times agree
+	past := time.Now().Add(-time.Hour)
+	filename := filepath.Join(dir, "modern", "src", "my-view1.js")
+	if err := os.Chtimes(filename, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	static := WithStaticFiles(&StaticFiles{Allow: []string{"/shared/*"}})
+	p := newTestServer(t, static)
+	other, err := New(
+		WithRoot(http.Dir(dir)),
+		WithConfigFile(filepath.Join(dir, "polymer.json")),
+		WithETagCache(2),
+		static,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := other.ETagCacheStats(); stats.Size != 2 || stats.Capacity != 2 {
+		t.Errorf("expected cache to be bounded: got %+v", stats)
+	}
+	if stats := p.ETagCacheStats(); stats.Size < 10 {
+		t.Errorf("expected build files to be hashed at load: got %+v", stats)
+	}
+
+	etag := func(h http.Handler, path string) string {
+		w := get(h, path, "unknown browser")
+		if expect := contentETag(w.Body.Bytes()); w.Header().Get("ETag") != expect {
+			t.Errorf("%s expected ETag %s: got %s", path, expect, w.Header().Get("ETag"))
+		}
+		return w.Header().Get("ETag")
+	}
+
+	for _, path := range []string{"/modern/src/my-view1.js", "/fallback/src/my-app.html", "/shared/logo.png"} {
+		if etag(p, path) != etag(other, path) {
+			t.Errorf("%s expected instances to have the same ETag", path)
+		}
+	}
+
+	// the cache is hit for files hashed at load
+	hits := p.ETagCacheStats().Hits
+	etag(p, "/modern/src/my-view2.js")
+	if p.ETagCacheStats().Hits != hits+1 {
+		t.Errorf("expected cache hit: got %+v", p.ETagCacheStats())
+	}
+
+	r := httptest.NewRequest("GET", "/modern/src/my-view1.js", nil)
+	r.Header.Set("If-None-Match", etag(other, "/modern/src/my-view1.js"))
+	w := httptest.NewRecorder()
+	other.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status 304: got %d", w.Code)
+	}
+
+	// changed files are hashed again
+	if err := ioutil.WriteFile(filename, []byte("// changed my-view1.js\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	other.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != contentETag([]byte("// changed my-view1.js\n")) {
+		t.Errorf("expected new ETag for changed file: got %d %s", w.Code, w.Header().Get("ETag"))
+	}
+
+	if _, err := New(WithETagCache(-1)); err == nil {
+		t.Error("expected error for negative cache size")
+	}
+}
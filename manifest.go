@@ -1,7 +1,10 @@
 package prpl
 
 import (
+	"bytes"
+	"fmt"
 	"os"
+	"sort"
 
 	"encoding/json"
 )
@@ -9,7 +12,11 @@ import (
 // Manifest is the preload manifest map where each value,
 // being a collection of resources to be preloaded,
 // keyed by a serving URL path which requires those resources.
-type Manifest map[string]map[string]AssetOpt
+type Manifest map[string]Assets
+
+// Assets are the resources to be preloaded for a URL path
+// keyed by the path of each resource.
+type Assets map[string]AssetOpt
 
 // AssetOpt defines a single resource options.
 type AssetOpt struct {
@@ -19,9 +26,17 @@ type AssetOpt struct {
 	// Weight is not used in the HTTP/2 preload spec
 	// but some HTTP/2 servers, while implementing stream priorities,
 	// could benefit from this manifest format as well.
+	// Resources are preloaded in order of descending weight.
 	Weight uint8 `json:"weight,omitempty"`
+
+	// order is the position the resource was declared in
+	order int
 }
 
+// defaultWeight is the weight of resources without one,
+// the same as the default HTTP/2 stream weight
+const defaultWeight = 16
+
 // ReadManifest reads a push manifest from name file.
 func ReadManifest(name string) (Manifest, error) {
 	f, err := os.Open(name)
@@ -37,3 +52,67 @@ func ReadManifest(name string) (Manifest, error) {
 
 	return m, nil
 }
+
+// UnmarshalJSON records the order the resources are declared in
+// so that resources with the same weight keep that order
+func (a *Assets) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		*a = nil
+		return nil
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("push manifest resources must be an object")
+	}
+
+	assets := Assets{}
+	for order := 0; dec.More(); order++ {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var asset AssetOpt
+		if err := dec.Decode(&asset); err != nil {
+			return err
+		}
+		asset.order = order
+		assets[token.(string)] = asset
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	*a = assets
+	return nil
+}
+
+// sorted returns the resource paths in order of descending
+// weight and then in the order they were declared
+func (a Assets) sorted() []string {
+	paths := make([]string, 0, len(a))
+	for path := range a {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		ai, aj := a[paths[i]], a[paths[j]]
+		if ai.weight() != aj.weight() {
+			return ai.weight() > aj.weight()
+		}
+		if ai.order != aj.order {
+			return ai.order < aj.order
+		}
+		return paths[i] < paths[j]
+	})
+	return paths
+}
+
+func (a AssetOpt) weight() int {
+	if a.Weight == 0 {
+		return defaultWeight
+	}
+	return int(a.Weight)
+}
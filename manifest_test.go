@@ -0,0 +1,250 @@
+package prpl
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestResolveURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		filename string
+		expect   string
+	}{
+		{"modern", "", "src/app.js", "/modern/src/app.js"},
+		{"modern", "", "./src/app.js", "/modern/src/app.js"},
+		{"modern", "", "../shared/app.js", "/shared/app.js"},
+		{"modern", "", "/shared/app.js", "/shared/app.js"},
+		{"modern", "", "/modern/src/app.js", "/modern/src/app.js"},
+		{"modern", "v1", "src/app.js", "/v1/modern/src/app.js"},
+		{"modern", "v1", "../shared/app.js", "/shared/app.js"},
+		{"modern", "v1", "/modern/src/app.js", "/v1/modern/src/app.js"},
+		{"modern", "v1", "/modernish/app.js", "/modernish/app.js"},
+		{"", "", "src/app.js", "/src/app.js"},
+		{"", "v1", "/shared//app.js", "/shared/app.js"},
+	}
+
+	for _, test := range tests {
+		if url := resolveURL(test.name, test.version, test.filename); url != test.expect {
+			t.Errorf("%s %s %s expected %s: got %s", test.name, test.version, test.filename, test.expect, url)
+		}
+	}
+}
+
+func TestManifestPaths(t *testing.T) {
+	for _, version := range []string{"", "v1"} {
+		p, err := New(
+			WithRoot(http.Dir("testdata/manifest")),
+			WithConfigFile("testdata/manifest/polymer.json"),
+			WithVersion(version),
+			WithRoutes(Routes{
+				"/view1": "src/my-view1.html",
+				"/view2": "/shared/my-view2.html",
+			}),
+			sharedFiles(),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		base := "/modern/"
+		if version != "" {
+			base = "/" + version + base
+		}
+
+		tests := []struct {
+			path   string
+			expect []string
+		}{
+			{"/view1", []string{
+				base + "src/build.js",
+				base + "src/dot.js",
+				base + "src/my-app.html",
+				base + "src/my-view1.html",
+				base + "src/relative.js",
+				base + "src/view1.js",
+				"/shared/absolute.js",
+				"/shared/parent.js",
+			}},
+			{"/view2", []string{
+				base + "src/build.js",
+				base + "src/dot.js",
+				base + "src/my-app.html",
+				base + "src/relative.js",
+				base + "src/view2.js",
+				"/shared/absolute.js",
+				"/shared/my-view2.html",
+				"/shared/parent.js",
+			}},
+		}
+
+		for _, test := range tests {
+			w := get(p, test.path, "unknown browser")
+			urls := []string{}
+			for _, link := range w.Header()["Link"] {
+				urls = append(urls, link[1:strings.Index(link, ">")])
+			}
+			sort.Strings(urls)
+			sort.Strings(test.expect)
+			if strings.Join(urls, "\n") != strings.Join(test.expect, "\n") {
+				t.Errorf("%s%s expected links:\n%s\ngot:\n%s", version, test.path, strings.Join(test.expect, "\n"), strings.Join(urls, "\n"))
+			}
+		}
+	}
+}
+
+func TestManifestOrder(t *testing.T) {
+	var m Manifest
+	err := json.Unmarshal([]byte(`{
+		"src/my-app.html": {
+			"d.js": {"type": "script"},
+			"c.js": {"type": "script", "weight": 1},
+			"b.js": {"type": "script", "weight": 200},
+			"a.js": {"type": "script"},
+			"e.js": {"type": "script", "weight": 16}
+		},
+		"empty.html": null
+	}`), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "b.js d.js a.js e.js c.js"
+	if order := strings.Join(m["src/my-app.html"].sorted(), " "); order != expect {
+		t.Errorf("expected %s: got %s", expect, order)
+	}
+
+	if err := json.Unmarshal([]byte(`{"src/my-app.html": ["a.js"]}`), &m); err == nil {
+		t.Error("expected error for invalid resources")
+	}
+}
+
+func TestPreloadOrder(t *testing.T) {
+	expect := []string{
+		"</fallback/bower_components/webcomponentsjs/webcomponents-loader.js>; rel=preload; as=script",
+		"</fallback/src/my-app.html>; rel=preload; as=document",
+		"</fallback/src/shared-styles.html>; rel=preload; as=document",
+		"</shared/logo.png>; rel=preload; as=image",
+		"</fallback/src/my-view1.html>; rel=preload; as=document",
+		"</fallback/src/my-view1.js>; rel=preload; as=script",
+	}
+
+	// the order doesn't depend on map iteration
+	for i := 0; i < 5; i++ {
+		p := newTestServer(t, WithRoutes(Routes{"/view1": "src/my-view1.html"}), sharedFiles())
+		w := get(p, "/view1", "unknown browser")
+		if links := w.Header()["Link"]; strings.Join(links, "\n") != strings.Join(expect, "\n") {
+			t.Fatalf("expected links:\n%s\ngot:\n%s", strings.Join(expect, "\n"), strings.Join(links, "\n"))
+		}
+	}
+
+	p := newTestServer(t,
+		WithRoutes(Routes{"/view1": "src/my-view1.html"}),
+		WithPreloadLimit(3),
+		sharedFiles(),
+	)
+	w := get(p, "/view1", "unknown browser")
+	if links := w.Header()["Link"]; strings.Join(links, "\n") != strings.Join(expect[:3], "\n") {
+		t.Errorf("expected links:\n%s\ngot:\n%s", strings.Join(expect[:3], "\n"), strings.Join(links, "\n"))
+	}
+
+	if _, err := New(WithPreloadLimit(-1)); err == nil {
+		t.Error("expected error for negative preload limit")
+	}
+}
+
+func TestPreloadConfig(t *testing.T) {
+	config, err := ConfigFromFile("testdata/app/polymer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Builds[0].Preload = []string{}
+	config.Builds[1].Preload = []string{"src/my-view2.js", "/shared/logo.png", "missing.js"}
+
+	options := []optionFn{
+		WithRoot(http.Dir("testdata/app")),
+		WithConfig(config),
+		WithRoutes(Routes{"/view1": "src/my-view1.html"}),
+		sharedFiles(),
+	}
+
+	p, err := New(options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := p.generation().report
+	if len(report) != 1 || report[0].Build != "fallback" || report[0].Fatal || !strings.HasSuffix(report[0].Path, "missing.js") {
+		t.Errorf("expected error for missing preload resource: got %v", report)
+	}
+
+	tests := []struct {
+		userAgent string
+		expect    []string
+	}{
+		{"unknown browser", []string{
+			"</fallback/src/my-view2.js>; rel=preload; as=script",
+			"</shared/logo.png>; rel=preload; as=image",
+			"</fallback/src/my-app.html>; rel=preload; as=document",
+		}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/60.0.3112.113 Safari/537.36", []string{
+			"</modern/src/my-app.html>; rel=preload; as=document",
+		}},
+	}
+
+	for _, test := range tests {
+		w := get(p, "/view1", test.userAgent)
+		links := w.Header()["Link"]
+		if len(links) < len(test.expect) || strings.Join(links[:len(test.expect)], "\n") != strings.Join(test.expect, "\n") {
+			t.Errorf("expected links to start with:\n%s\ngot:\n%s", strings.Join(test.expect, "\n"), strings.Join(links, "\n"))
+		}
+	}
+
+	if _, err := New(append(options, WithStrict(true))...); err == nil {
+		t.Error("expected strict mode to fail for missing preload resource")
+	}
+}
+
+func TestPreloadNotServed(t *testing.T) {
+	config, err := ConfigFromFile("testdata/app/polymer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Builds[1].Preload = []string{"src/my-view2.js", "/shared/logo.png"}
+
+	// files outside of the builds are not served by default
+	p, err := New(
+		WithRoot(http.Dir("testdata/app")),
+		WithConfig(config),
+		WithRoutes(Routes{"/view1": "src/my-view1.html"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{}
+	for _, err := range p.generation().report {
+		if err.Fatal {
+			t.Errorf("expected warning: got %v", err)
+		}
+		paths = append(paths, err.Build+" "+err.Path)
+	}
+	sort.Strings(paths)
+	expect := []string{"fallback /shared/logo.png", "fallback /shared/logo.png", "modern /shared/logo.png"}
+	if strings.Join(paths, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("expected unserved resources to be reported:\n%s\ngot:\n%s", strings.Join(expect, "\n"), strings.Join(paths, "\n"))
+	}
+
+	for _, userAgent := range []string{"unknown browser", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/60.0.3112.113 Safari/537.36"} {
+		for _, link := range get(p, "/view1", userAgent).Header()["Link"] {
+			if strings.Contains(link, "/shared/") {
+				t.Errorf("expected unserved resource not to be preloaded: got %s", link)
+			}
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package prpl
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/ua-parser/uap-go/uaparser"
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// CapabilityMatrix is a versioned browser support matrix that
+	// can be used instead of the inbuilt browser predicates. It is
+	// keyed by uaparser browser family and then capability name.
+	CapabilityMatrix struct {
+		Version  int                              `json:"version" yaml:"version"`
+		Browsers map[string]map[string]MatrixRule `json:"browsers" yaml:"browsers"`
+	}
+
+	// MatrixRule defines the minimum browser version, and optionally
+	// the minimum operating system version, supporting a capability.
+	// In a matrix file a rule can be written as a version string, as
+	// an object with "since" and "os" versions, or as a boolean.
+	MatrixRule struct {
+		Supported bool
+		Since     string
+		OS        string
+
+		// invalid is set when the rule could not be decoded
+		// so the error can be reported with the rule path
+		invalid string
+	}
+
+	// capabilityMatrix maps browser families to the
+	// predicate for each capability they support
+	capabilityMatrix map[string]map[capability]Predicate
+)
+
+// matrixVersion is the supported capability matrix file version
+const matrixVersion = 1
+
+// CapabilityMatrixFromFile loads a capability matrix from a JSON
+// or YAML file, based on the file extension
+func CapabilityMatrixFromFile(filename string) (*CapabilityMatrix, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var matrix CapabilityMatrix
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &matrix)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err = dec.Decode(&matrix); err != nil {
+			err = jsonErrorPosition(data, err)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if err := matrix.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	return &matrix, nil
+}
+
+// Validate checks the matrix version, that every capability is
+// registered and that every version can be parsed
+func (m *CapabilityMatrix) Validate() error {
+	_, err := m.compile()
+	return err
+}
+
+func (m *CapabilityMatrix) compile() (capabilityMatrix, error) {
+	if m.Version != matrixVersion {
+		return nil, fmt.Errorf("unsupported capability matrix version %d, expected %d", m.Version, matrixVersion)
+	}
+	if len(m.Browsers) == 0 {
+		return nil, fmt.Errorf("capability matrix has no browsers")
+	}
+
+	errs := []string{}
+	compiled := capabilityMatrix{}
+	for family, rules := range m.Browsers {
+		if family == "" {
+			errs = append(errs, "browsers: empty browser family")
+			continue
+		}
+		compiled[family] = make(map[capability]Predicate, len(rules))
+		for name, rule := range rules {
+			path := fmt.Sprintf("browsers.%s.%s", family, name)
+			c, ok := capabilityByName(name)
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s: unknown capability %q", path, name))
+				continue
+			}
+			predicate, err := rule.predicate()
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+				continue
+			}
+			compiled[family][c] = predicate
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, fmt.Errorf("invalid capability matrix:\n\t%s", strings.Join(errs, "\n\t"))
+	}
+
+	return compiled, nil
+}
+
+func (r MatrixRule) predicate() (Predicate, error) {
+	if r.invalid != "" {
+		return nil, fmt.Errorf("%s", r.invalid)
+	}
+	if !r.Supported {
+		if r.Since != "" || r.OS != "" {
+			return nil, fmt.Errorf("unsupported rule cannot have versions")
+		}
+		return notyet, nil
+	}
+
+	since, err := parseMatrixVersion(r.Since)
+	if err != nil {
+		return nil, err
+	}
+	if r.OS == "" {
+		return Since(since...), nil
+	}
+
+	os, err := parseMatrixVersion(r.OS)
+	if err != nil {
+		return nil, fmt.Errorf("os: %v", err)
+	}
+	return func(client *uaparser.Client) bool {
+		return versionAtLeast(parseVersion(client.UserAgent), since...) &&
+			versionAtLeast(parseVersion(client.Os), os...)
+	}, nil
+}
+
+func parseMatrixVersion(version string) ([]int, error) {
+	if version == "" {
+		return nil, fmt.Errorf("version is required")
+	}
+	parts := strings.Split(version, ".")
+	values := make([]int, len(parts))
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid version %q", version)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// UnmarshalJSON accepts a version string or number, a boolean
+// or an object with "since" and "os" versions
+func (r *MatrixRule) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return err
+	}
+	r.fromValue(value)
+	return nil
+}
+
+// UnmarshalYAML accepts the same forms as UnmarshalJSON
+func (r *MatrixRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	r.fromValue(value)
+	return nil
+}
+
+func (r *MatrixRule) fromValue(value interface{}) {
+	*r = MatrixRule{}
+	switch v := value.(type) {
+	case bool:
+		r.Supported = v
+	case string:
+		r.Supported, r.Since = true, v
+	case json.Number:
+		r.Supported, r.Since = true, v.String()
+	case int:
+		r.Supported, r.Since = true, strconv.Itoa(v)
+	case float64:
+		r.Supported, r.Since = true, strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]interface{}:
+		r.fromMap(v)
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = value
+		}
+		r.fromMap(m)
+	default:
+		r.invalid = fmt.Sprintf("invalid rule %v, expected version, boolean or object", value)
+	}
+}
+
+func (r *MatrixRule) fromMap(m map[string]interface{}) {
+	r.Supported = true
+	for key, value := range m {
+		var version string
+		switch v := value.(type) {
+		case string:
+			version = v
+		case json.Number:
+			version = v.String()
+		case int:
+			version = strconv.Itoa(v)
+		case float64:
+			version = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			r.invalid = fmt.Sprintf("invalid %s version %v", key, value)
+			return
+		}
+		switch key {
+		case "since":
+			r.Since = version
+		case "os":
+			r.OS = version
+		default:
+			r.invalid = fmt.Sprintf("unknown rule field %q", key)
+			return
+		}
+	}
+}
+
+func (m capabilityMatrix) evaluate(client *uaparser.Client) capability {
+	var capabilities capability
+	for c, predicate := range m[client.UserAgent.Family] {
+		if predicate(client) {
+			capabilities |= c
+		}
+	}
+	return capabilities
+}
+
+// jsonErrorPosition adds the line and column to JSON syntax errors
+func jsonErrorPosition(data []byte, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return err
+	}
+
+	line, col := 1, 1
+	for _, b := range data[:offset] {
+		if b == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return fmt.Errorf("line %d, column %d: %v", line, col, err)
+}
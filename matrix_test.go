@@ -0,0 +1,55 @@
+package prpl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCapabilityMatrixFile(t *testing.T) {
+	for _, filename := range []string{"testdata/matrix.json", "testdata/matrix.yaml"} {
+		p := newTestServer(t, WithCapabilityMatrixFile(filename))
+
+		tests := []struct {
+			userAgent    string
+			capabilities capability
+		}{
+			// chrome has all the capabilities in the matrix
+			{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.96 Safari/537.36", es2015 + push + serviceworker},
+
+			// browsers not in the matrix have no capabilities
+			{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/52.0.2743.116 Safari/537.36 Edge/15.15063", 0},
+
+			// safari push capability is predicated on macOS version
+			{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_10) AppleWebKit/603.1.30 (KHTML, like Gecko) Version/10.1 Safari/603.1.30", es2015},
+			{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_11) AppleWebKit/603.1.30 (KHTML, like Gecko) Version/10.1 Safari/603.1.30", es2015 + push},
+		}
+
+		for _, test := range tests {
+			if capabilities := p.browserCapabilities(test.userAgent); capabilities != test.capabilities {
+				t.Errorf("%s: expected %s to have %s: got %s", filename, test.userAgent, test.capabilities, capabilities)
+			}
+		}
+	}
+}
+
+func TestCapabilityMatrixErrors(t *testing.T) {
+	_, err := CapabilityMatrixFromFile("testdata/matrix-invalid.json")
+	if err == nil {
+		t.Fatal("expected error for invalid matrix")
+	}
+
+	for _, expect := range []string{
+		`browsers.Chrome.es2015: invalid version "49.x"`,
+		`browsers.Chrome.unknown: unknown capability "unknown"`,
+		`browsers.Safari.push: os: invalid version "ten"`,
+		`browsers.Safari.serviceworker: unknown rule field "until"`,
+	} {
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("expected error to contain %q: got %v", expect, err)
+		}
+	}
+
+	if err := (&CapabilityMatrix{Version: 2, Browsers: map[string]map[string]MatrixRule{}}).Validate(); err == nil {
+		t.Error("expected error for unsupported version")
+	}
+}
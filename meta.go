@@ -0,0 +1,182 @@
+package prpl
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	"net/http"
+)
+
+type (
+	// RouteMeta is the metadata injected into the entrypoint head
+	// for a route so that crawlers and link unfurlers see the
+	// correct title, description and preview for each page
+	RouteMeta struct {
+		Title       string
+		Description string
+		Canonical   string
+
+		// OpenGraph properties keyed without the "og:" prefix, the
+		// title, description and url default to the values above
+		OpenGraph map[string]string
+
+		// Twitter card properties keyed without the "twitter:" prefix
+		Twitter map[string]string
+	}
+
+	// RouteMetadata maps route patterns to their metadata, using
+	// the same patterns and precedence as Routes
+	RouteMetadata map[string]*RouteMeta
+
+	// RouteMetaFn can set dynamic metadata for a request, such as a
+	// product name. It is passed a copy of the metadata for the route,
+	// which is empty if no route matches.
+	RouteMetaFn func(r *http.Request, meta *RouteMeta) error
+)
+
+// routeMetaTransform injects the route metadata into the entrypoint
+func routeMetaTransform(metadata RouteMetadata, routes routeTable, fn RouteMetaFn) *entrypointTransform {
+	return &entrypointTransform{
+		name: "route metadata",
+		apply: func(r *http.Request, body []byte) ([]byte, error) {
+			meta := &RouteMeta{}
+			if pattern, ok := routes.match(r.URL.Path); ok {
+				meta = metadata[pattern].copy()
+			}
+			if fn != nil {
+				if err := fn(r, meta); err != nil {
+					return nil, err
+				}
+			}
+			return meta.inject(body)
+		},
+	}
+}
+
+// copy returns a copy of the metadata that can be changed without
+// affecting the registered metadata, or empty metadata if it is nil
+func (m *RouteMeta) copy() *RouteMeta {
+	if m == nil {
+		return &RouteMeta{}
+	}
+	c := *m
+	c.OpenGraph = copyProperties(m.OpenGraph)
+	c.Twitter = copyProperties(m.Twitter)
+	return &c
+}
+
+func copyProperties(properties map[string]string) map[string]string {
+	if properties == nil {
+		return nil
+	}
+	c := make(map[string]string, len(properties))
+	for key, value := range properties {
+		c[key] = value
+	}
+	return c
+}
+
+var (
+	// headTagPattern matches a meta or link tag, with the
+	// indentation and line break around it
+	headTagPattern = regexp.MustCompile(`(?is)[ \t]*<(meta|link)\b[^>]*>[ \t]*\r?\n?`)
+
+	// attrPattern matches a tag attribute and its value
+	attrPattern = regexp.MustCompile(`(?s)([a-zA-Z][a-zA-Z0-9:_-]*)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+)
+
+// inject replaces the entrypoint title, if there is one, and
+// inserts the other tags before the closing head tag, removing
+// any tags the entrypoint already has for the same properties
+func (m *RouteMeta) inject(body []byte) ([]byte, error) {
+	var tags bytes.Buffer
+	names := map[string]bool{}
+
+	if m.Title != "" {
+		title := "<title>" + html.EscapeString(m.Title) + "</title>"
+		start, end := indexFold(body, "<title"), indexFold(body, "</title>")
+		if start != -1 && end > start {
+			end += len("</title>")
+			replaced := make([]byte, 0, len(body)+len(title))
+			replaced = append(replaced, body[:start]...)
+			replaced = append(replaced, title...)
+			body = append(replaced, body[end:]...)
+		} else {
+			tags.WriteString(title + "\n")
+		}
+	}
+
+	if m.Description != "" {
+		names["description"] = true
+		fmt.Fprintf(&tags, "<meta name=\"description\" content=\"%s\">\n", html.EscapeString(m.Description))
+	}
+	if m.Canonical != "" {
+		fmt.Fprintf(&tags, "<link rel=\"canonical\" href=\"%s\">\n", html.EscapeString(m.Canonical))
+	}
+
+	og := map[string]string{
+		"title":       m.Title,
+		"description": m.Description,
+		"url":         m.Canonical,
+	}
+	for key, value := range m.OpenGraph {
+		og[key] = value
+	}
+	writeMetaTags(&tags, names, "property", "og:", og)
+	writeMetaTags(&tags, names, "name", "twitter:", m.Twitter)
+
+	if tags.Len() == 0 {
+		return body, nil
+	}
+	body = removeHeadTags(body, names, m.Canonical != "")
+	return insertBeforeHeadEnd(body, tags.Bytes())
+}
+
+// removeHeadTags removes the meta tags in the head with a name or
+// property in names, and the canonical link if canonical is true
+func removeHeadTags(body []byte, names map[string]bool, canonical bool) []byte {
+	end := indexFold(body, "</head>")
+	if end == -1 {
+		return body
+	}
+
+	head := headTagPattern.ReplaceAllFunc(body[:end], func(tag []byte) []byte {
+		attrs := map[string]string{}
+		for _, match := range attrPattern.FindAllSubmatch(tag, -1) {
+			value := strings.Trim(string(match[2]), `"'`)
+			attrs[strings.ToLower(string(match[1]))] = strings.ToLower(html.UnescapeString(value))
+		}
+		switch {
+		case names[attrs["name"]], names[attrs["property"]]:
+			return nil
+		case canonical && attrs["rel"] == "canonical":
+			return nil
+		}
+		return tag
+	})
+
+	result := make([]byte, 0, len(head)+len(body)-end)
+	result = append(result, head...)
+	return append(result, body[end:]...)
+}
+
+// writeMetaTags writes the non-empty properties in key order,
+// adding the names of the tags written to names
+func writeMetaTags(w *bytes.Buffer, names map[string]bool, attr, prefix string, properties map[string]string) {
+	keys := make([]string, 0, len(properties))
+	for key, value := range properties {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		names[strings.ToLower(prefix+key)] = true
+		fmt.Fprintf(w, "<meta %s=\"%s\" content=\"%s\">\n", attr, html.EscapeString(prefix+key), html.EscapeString(properties[key]))
+	}
+}
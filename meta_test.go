@@ -0,0 +1,190 @@
+package prpl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"net/http"
+)
+
+func TestRouteMeta(t *testing.T) {
+	p := newTestServer(t,
+		WithRouteMeta(RouteMetadata{
+			"/view1": {
+				Title:       "View <One>",
+				Description: "The first view",
+				Canonical:   "https://example.com/view1",
+				Twitter:     map[string]string{"card": "summary"},
+			},
+			"/products/*": {Title: "Product"},
+		}),
+		WithRouteMetaFn(func(r *http.Request, meta *RouteMeta) error {
+			if r.URL.Path == "/products/fail" {
+				return errors.New("failed")
+			}
+			if name := strings.TrimPrefix(r.URL.Path, "/products/"); name != r.URL.Path {
+				meta.Title += " " + name
+				meta.OpenGraph = map[string]string{"type": "product"}
+			}
+			return nil
+		}),
+	)
+
+	tests := []struct {
+		path    string
+		expect  []string
+		exclude []string
+	}{
+		{
+			"/view1",
+			[]string{
+				"<title>View &lt;One&gt;</title>",
+				`<meta name="description" content="The first view">`,
+				`<link rel="canonical" href="https://example.com/view1">`,
+				`<meta property="og:description" content="The first view">`,
+				`<meta property="og:title" content="View &lt;One&gt;">`,
+				`<meta property="og:url" content="https://example.com/view1">`,
+				`<meta name="twitter:card" content="summary">`,
+			},
+			[]string{"<title>My App</title>"},
+		},
+		{
+			"/products/widget",
+			[]string{
+				"<title>Product widget</title>",
+				`<meta property="og:title" content="Product widget">`,
+				`<meta property="og:type" content="product">`,
+			},
+			[]string{"description"},
+		},
+
+		// unmatched routes and failures serve the unmodified entrypoint
+		{"/view2", []string{"<title>My App</title>"}, []string{"<meta"}},
+		{"/products/fail", []string{"<title>My App</title>"}, []string{"<meta"}},
+	}
+
+	for _, test := range tests {
+		w := get(p, test.path, "unknown browser")
+		body := w.Body.String()
+		if w.Code != http.StatusOK {
+			t.Errorf("%s expected status 200: got %d", test.path, w.Code)
+		}
+		for _, expect := range test.expect {
+			if !strings.Contains(body, expect) {
+				t.Errorf("%s expected body to contain %q: got %s", test.path, expect, body)
+			}
+		}
+		for _, exclude := range test.exclude {
+			if strings.Contains(body, exclude) {
+				t.Errorf("%s expected body not to contain %q: got %s", test.path, exclude, body)
+			}
+		}
+		if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "public, max-age=0" {
+			t.Errorf("%s expected public Cache-Control: got %s", test.path, cacheControl)
+		}
+	}
+}
+
+func TestRouteMetaCopy(t *testing.T) {
+	metadata := RouteMetadata{
+		"/view1": {
+			Title:     "View One",
+			OpenGraph: map[string]string{"image": "/img/a.png"},
+			Twitter:   map[string]string{"card": "summary"},
+		},
+		"/view2": nil,
+	}
+	p := newTestServer(t,
+		WithRouteMeta(metadata),
+		WithRouteMetaFn(func(r *http.Request, meta *RouteMeta) error {
+			if meta.OpenGraph != nil {
+				meta.OpenGraph["image"] = "/img/b.png"
+				meta.Twitter["card"] = "summary_large_image"
+			}
+			return nil
+		}),
+	)
+
+	for _, path := range []string{"/view1", "/view2"} {
+		if w := get(p, path, "unknown browser"); w.Code != http.StatusOK {
+			t.Errorf("%s expected status 200: got %d", path, w.Code)
+		}
+	}
+
+	meta := metadata["/view1"]
+	if image := meta.OpenGraph["image"]; image != "/img/a.png" {
+		t.Errorf("expected registered og:image to be unchanged: got %s", image)
+	}
+	if card := meta.Twitter["card"]; card != "summary" {
+		t.Errorf("expected registered twitter:card to be unchanged: got %s", card)
+	}
+}
+
+func TestRouteMetaReplacesTags(t *testing.T) {
+	p, err := New(
+		WithRoot(http.Dir("testdata/meta")),
+		WithConfig(&ProjectConfig{}),
+		WithRouteMeta(RouteMetadata{
+			"/view1": {
+				Title:       "View One",
+				Description: "The first view",
+				Canonical:   "https://example.com/view1",
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		expect  []string
+		exclude []string
+	}{
+		{
+			"/view1",
+			[]string{
+				`<meta name="description" content="The first view">`,
+				`<meta property="og:title" content="View One">`,
+				`<link rel="canonical" href="https://example.com/view1">`,
+
+				// tags that aren't set are kept
+				`<meta name="theme-color" content="#3f51b5">`,
+				`<meta name="twitter:card" content="summary">`,
+				`<link rel="manifest" href="/manifest.json">`,
+			},
+			[]string{
+				`<meta name="description" content="My App description">`,
+				`<meta property="og:title" content="My App">`,
+				`<link rel="canonical" href="https://example.com/">`,
+			},
+		},
+		{
+			"/view2",
+			[]string{
+				`<meta name="description" content="My App description">`,
+				`<meta property="og:title" content="My App">`,
+				`<link rel="canonical" href="https://example.com/">`,
+			},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		body := get(p, test.path, "unknown browser").Body.String()
+		for _, expect := range test.expect {
+			if strings.Count(body, expect) != 1 {
+				t.Errorf("%s expected body to contain %q once: got %s", test.path, expect, body)
+			}
+		}
+		for _, exclude := range test.exclude {
+			if strings.Contains(body, exclude) {
+				t.Errorf("%s expected body not to contain %q: got %s", test.path, exclude, body)
+			}
+		}
+		if count := strings.Count(body, `name="description"`); count != 1 {
+			t.Errorf("%s expected one description: got %d", test.path, count)
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package prpl
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
 	"net/http"
+	"sync/atomic"
 
 	"github.com/ua-parser/uap-go/uaparser"
 )
@@ -9,29 +15,83 @@ import (
 type (
 	// prpl is an instance of the prpl-server service
 	prpl struct {
-		http.Handler
-		parser         *uaparser.Parser
-		config         *ProjectConfig
-		builds         builds
-		root           http.Dir
-		routes         Routes
-		staticHandlers map[string]http.Handler
-		createTemplate createTemplateFn
-		usePush        bool
+		parser          *uaparser.Parser
+		matrix          capabilityMatrix
+		detectors       detectors
+		detectHeaders   []string
+		acceptCH        string
+		vary            string
+		cacheSize       int
+		capabilityCache *lruCache
+		etagCacheSize   int
+		etagCache       *lruCache
+		config          *ProjectConfig
+		configFile      string
+		current         atomic.Value
+		reloadMu        sync.Mutex
+		watchInterval   time.Duration
+		done            chan struct{}
+		closeOnce       sync.Once
+		root            http.Dir
+		version         string
+		routes          Routes
+		routesFile      string
+		fragmentRoutes  bool
+		fragmentRoute   FragmentRouteFn
+		staticHandlers  map[string]http.Handler
+		staticFiles     *StaticFiles
+		createTemplate  createTemplateFn
+		notFoundHandler http.Handler
+		notFoundPage    string
+		transforms      []*entrypointTransform
+		routeMeta       RouteMetadata
+		metaRoutes      routeTable
+		routeMetaFn     RouteMetaFn
+		initialState    InitialStateFn
+		stateLimit      int
+		pushMode        PushMode
+		earlyHints      bool
+		preloadLimit    int
+		strict          bool
 	}
 
 	// optionFn provides functional option configuration
 	optionFn func(*prpl) error
+
+	// PushMode controls how push-manifest resources are sent
+	PushMode int
+)
+
+const (
+	// PushNone disables push and link preload headers
+	PushNone PushMode = 0
+
+	// PushLink sends link preload headers only, relying on
+	// a proxy such as nghttpx to convert them to pushes
+	PushLink PushMode = 1
+
+	// PushNative uses http.Pusher when the connection supports
+	// it, falling back to link preload headers otherwise
+	PushNative PushMode = 2
+
+	// PushBoth uses http.Pusher and also sends link preload
+	// headers for every resource
+	PushBoth = PushLink | PushNative
 )
 
 // New creates a new prpl instance
 func New(options ...optionFn) (*prpl, error) {
 	p := prpl{
-		parser:         uaparser.NewFromSaved(),
-		root:           http.Dir("."),
-		staticHandlers: make(map[string]http.Handler),
-		createTemplate: createDefaultTemplate,
-		usePush:        true,
+		parser:          uaparser.NewFromSaved(),
+		root:            http.Dir("."),
+		staticHandlers:  make(map[string]http.Handler),
+		createTemplate:  createDefaultTemplate,
+		notFoundHandler: http.NotFoundHandler(),
+		pushMode:        PushLink,
+		cacheSize:       1000,
+		etagCacheSize:   10000,
+		stateLimit:      64 << 10,
+		fragmentRoute:   DefaultFragmentRoute,
 	}
 
 	for _, option := range options {
@@ -41,16 +101,65 @@ func New(options ...optionFn) (*prpl, error) {
 	}
 
 	// use polymer.json for build file by default
-	if p.config == nil {
+	if p.config == nil && p.configFile == "" {
 		if err := WithConfigFile("polymer.json")(&p); err != nil {
 			return nil, err
 		}
 	}
 
-	// TODO: pass p in rather than all the properties
-	p.builds = loadBuilds(p.config, p.root, p.routes, p.createTemplate)
+	// detect capabilities from the user agent and client hints by default
+	if p.detectors == nil {
+		p.detectors = detectors{
+			NewUserAgentDetector(p.parser),
+			NewClientHintsDetector(),
+		}
+	}
+
+	// evaluate browser predicates using the instance matrix
+	if p.matrix != nil {
+		chain := make(detectors, len(p.detectors))
+		for i, detector := range p.detectors {
+			if d, ok := detector.(matrixDetector); ok {
+				detector = d.withMatrix(p.matrix)
+			}
+			chain[i] = detector
+		}
+		p.detectors = chain
+	}
+	p.detectHeaders = p.detectors.headers()
+	p.acceptCH, p.vary = p.detectors.responseHeaders()
+	if p.cacheSize > 0 {
+		p.capabilityCache = newLRUCache(p.cacheSize)
+	}
+	if p.etagCacheSize > 0 {
+		p.etagCache = newLRUCache(p.etagCacheSize)
+	}
+
+	if p.staticFiles != nil {
+		staticFiles, err := newStaticFiles(p.staticFiles, p.root)
+		if err != nil {
+			return nil, err
+		}
+		p.staticFiles = staticFiles
+	}
+
+	if p.routeMeta != nil || p.routeMetaFn != nil {
+		p.transforms = append(p.transforms, routeMetaTransform(p.routeMeta, p.metaRoutes, p.routeMetaFn))
+	}
+	if p.initialState != nil {
+		p.transforms = append(p.transforms, initialStateTransform(p.initialState, p.stateLimit))
+	}
 
-	p.Handler = p.createHandler()
+	g, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	p.current.Store(g)
+
+	p.done = make(chan struct{})
+	if p.watchInterval > 0 {
+		go p.watch(p.watchInterval)
+	}
 
 	return &p, nil
 }
@@ -63,6 +172,74 @@ func WithRoutes(routes Routes) optionFn {
 	}
 }
 
+// WithRoutesFile loads the route -> fragment mapping from a JSON
+// file, which is read again whenever the builds are reloaded. Routes
+// in the file take precedence over routes in the project configuration
+// and routes set using WithRoutes take precedence over both. An empty
+// filename means no routes file is used.
+func WithRoutesFile(filename string) optionFn {
+	return func(p *prpl) error {
+		if filename == "" {
+			p.routesFile = ""
+			return nil
+		}
+		if _, err := RoutesFromFile(filename); err != nil {
+			return err
+		}
+		p.routesFile = filename
+		return nil
+	}
+}
+
+// WithFragmentRoutes derives a route for each of the fragments in
+// the project configuration, e.g. src/my-view1.html is routed from
+// /view1. Routes that are configured explicitly take precedence.
+// The derived routes are logged when the builds are loaded.
+func WithFragmentRoutes(enabled bool) optionFn {
+	return func(p *prpl) error {
+		p.fragmentRoutes = enabled
+		return nil
+	}
+}
+
+// WithFragmentRouteName sets the naming rule used to derive the
+// route for each fragment, replacing DefaultFragmentRoute
+func WithFragmentRouteName(name FragmentRouteFn) optionFn {
+	return func(p *prpl) error {
+		if name == nil {
+			return fmt.Errorf("fragment route name is nil")
+		}
+		p.fragmentRoute = name
+		return nil
+	}
+}
+
+// WithStrict controls how problems loading the builds are handled.
+// In strict mode any problem, such as a missing push manifest, is
+// returned as an error from New. Otherwise problems are logged as
+// warnings and builds that cannot be served are skipped.
+func WithStrict(strict bool) optionFn {
+	return func(p *prpl) error {
+		p.strict = strict
+		return nil
+	}
+}
+
+// WithVersion sets a version to prefix the build paths with so
+// that static files can be cached indefinitely, e.g. /modern/ is
+// served from /version/modern/. The base href in each entrypoint
+// and the link preload headers are updated to include it.
+func WithVersion(version string) optionFn {
+	return func(p *prpl) error {
+		version = strings.Trim(version, "/")
+		if strings.Contains(version, "/") {
+			return fmt.Errorf("invalid version %q", version)
+		}
+		p.version = version
+		return nil
+	}
+}
+
 // WithRoot sets the root directory
 func WithRoot(root http.Dir) optionFn {
 	return func(p *prpl) error {
@@ -75,11 +252,13 @@ func WithRoot(root http.Dir) optionFn {
 func WithConfig(config *ProjectConfig) optionFn {
 	return func(p *prpl) error {
 		p.config = config
+		p.configFile = ""
 		return nil
 	}
 }
 
-// WithConfigFile loads the project configuration
+// WithConfigFile loads the project configuration. The file is
+// read again whenever the builds are reloaded.
 func WithConfigFile(filename string) optionFn {
 	return func(p *prpl) error {
 		config, err := ConfigFromFile(filename)
@@ -87,6 +266,19 @@ func WithConfigFile(filename string) optionFn {
 			return err
 		}
 		p.config = config
+		p.configFile = filename
+		return nil
+	}
+}
+
+// WithWatch enables hot reloading of the builds by polling the
+// configuration, push manifest and entrypoint files at the given
+// interval. A new set of builds is only swapped in if every build
+// can be loaded, otherwise the previous builds continue to be
+// served. Call Close to stop watching.
+func WithWatch(interval time.Duration) optionFn {
+	return func(p *prpl) error {
+		p.watchInterval = interval
 		return nil
 	}
 }
@@ -117,6 +309,69 @@ func WithUAParserBytes(data []byte) optionFn {
 	}
 }
 
+// WithCapabilityMatrix replaces the inbuilt browser predicates
+// with a browser support matrix
+func WithCapabilityMatrix(matrix *CapabilityMatrix) optionFn {
+	return func(p *prpl) error {
+		compiled, err := matrix.compile()
+		if err != nil {
+			return err
+		}
+		p.matrix = compiled
+		return nil
+	}
+}
+
+// WithCapabilityMatrixFile loads a browser support matrix from
+// a JSON or YAML file to replace the inbuilt browser predicates
+func WithCapabilityMatrixFile(filename string) optionFn {
+	return func(p *prpl) error {
+		matrix, err := CapabilityMatrixFromFile(filename)
+		if err != nil {
+			return err
+		}
+		return WithCapabilityMatrix(matrix)(p)
+	}
+}
+
+// WithDetectors sets the chain of detectors used to determine
+// browser capabilities, replacing the default user agent and
+// client hints detectors. Use NewUserAgentDetector to include
+// user agent parsing in the chain.
+func WithDetectors(detectors ...Detector) optionFn {
+	return func(p *prpl) error {
+		p.detectors = detectors
+		return nil
+	}
+}
+
+// WithCapabilityCache sets the number of detection results to
+// cache, keyed by the request headers the detectors use. The
+// default is 1000 and a size of 0 disables the cache.
+func WithCapabilityCache(size int) optionFn {
+	return func(p *prpl) error {
+		if size < 0 {
+			return fmt.Errorf("invalid capability cache size %d", size)
+		}
+		p.cacheSize = size
+		return nil
+	}
+}
+
+// WithETagCache sets the number of file content hashes to cache
+// for ETags, which are computed for every build file when the builds
+// are loaded. Files that aren't cached are hashed when requested. The
+// default is 10000 and a size of 0 disables the cache.
+func WithETagCache(size int) optionFn {
+	return func(p *prpl) error {
+		if size < 0 {
+			return fmt.Errorf("invalid ETag cache size %d", size)
+		}
+		p.etagCacheSize = size
+		return nil
+	}
+}
+
 // WithStaticHandler allows the handler for certain static
 // files to be overridden. This could be used to customize
 // the manifest.json file per tenant or to serve specific
@@ -128,6 +383,36 @@ func WithStaticHandler(path string, handler http.Handler) optionFn {
 	}
 }
 
+// WithStaticFiles serves files from outside of the builds, such
+// as robots.txt. They are not served by default, and serving them
+// from the server root requires an allow list.
+func WithStaticFiles(config *StaticFiles) optionFn {
+	return func(p *prpl) error {
+		p.staticFiles = config
+		return nil
+	}
+}
+
+// WithNotFound sets the handler for requests that are not found,
+// which are paths with a file extension that don't exist
+func WithNotFound(handler http.Handler) optionFn {
+	return func(p *prpl) error {
+		p.notFoundHandler = handler
+		return nil
+	}
+}
+
+// WithNotFoundPage serves the file from the build directory, such as
+// 404.html, for requests that are not found. The page from the best
+// build for the browser is used, falling back to the not found handler
+// for builds without one.
+func WithNotFoundPage(filename string) optionFn {
+	return func(p *prpl) error {
+		p.notFoundPage = filename
+		return nil
+	}
+}
+
 // WithRouteTemplate allows the entrypoint to be converted
 // into a template so that the output can be transformed if
 // required
@@ -138,11 +423,90 @@ func WithRouteTemplate(factory createTemplateFn) optionFn {
 	}
 }
 
+// WithInitialState injects the initial application state for each
+// request into the entrypoint, saving the app a round trip to fetch
+// it. The state is JSON encoded into a <script type="application/json"
+// id="initial-state"> element before </head>. If the function returns
+// an error, or the state exceeds the size limit, the entrypoint is
+// served without it.
+func WithInitialState(state InitialStateFn) optionFn {
+	return func(p *prpl) error {
+		p.initialState = state
+		return nil
+	}
+}
+
+// WithInitialStateLimit sets the maximum size in bytes of the JSON
+// encoded initial state. The default is 64KiB and 0 means no limit.
+func WithInitialStateLimit(limit int) optionFn {
+	return func(p *prpl) error {
+		if limit < 0 {
+			return fmt.Errorf("invalid initial state limit %d", limit)
+		}
+		p.stateLimit = limit
+		return nil
+	}
+}
+
+// WithRouteMeta injects the title, description, canonical link and
+// OpenGraph / Twitter tags for each route into the entrypoint head
+func WithRouteMeta(metadata RouteMetadata) optionFn {
+	return func(p *prpl) error {
+		patterns := make([]string, 0, len(metadata))
+		for pattern := range metadata {
+			patterns = append(patterns, pattern)
+		}
+		routes, err := compileRoutes(patterns)
+		if err != nil {
+			return err
+		}
+		p.routeMeta = metadata
+		p.metaRoutes = routes
+		return nil
+	}
+}
+
+// WithRouteMetaFn sets a callback to provide dynamic metadata
+// for a request, such as the name of a product
+func WithRouteMetaFn(fn RouteMetaFn) optionFn {
+	return func(p *prpl) error {
+		p.routeMetaFn = fn
+		return nil
+	}
+}
+
+// WithEarlyHints sends a 103 Early Hints response with the link
+// preload headers before rendering the entrypoint, and before serving
+// static documents that have push manifest entries. Browsers that no
+// longer support push can start fetching the resources while the
+// response is being prepared.
+func WithEarlyHints(enabled bool) optionFn {
+	return func(p *prpl) error {
+		p.earlyHints = enabled
+		return nil
+	}
+}
+
+// WithPreloadLimit sets the maximum number of resources pushed or
+// preloaded for each route, 0 means no limit. Resources are added in
+// order: the build's preload resources, the shell, the shell's manifest
+// resources, the fragment and the fragment's manifest resources, with
+// the resources of each manifest entry sorted by descending weight.
+func WithPreloadLimit(limit int) optionFn {
+	return func(p *prpl) error {
+		if limit < 0 {
+			return fmt.Errorf("invalid preload limit %d", limit)
+		}
+		p.preloadLimit = limit
+		return nil
+	}
+}
+
 // WithPush allows control over the sending of http server
 // push / link headers
-func WithPush(usePush bool) optionFn {
+func WithPush(mode PushMode) optionFn {
 	return func(p *prpl) error {
-		p.usePush = usePush
+		p.pushMode = mode
 		return nil
 	}
 }
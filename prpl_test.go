@@ -0,0 +1,78 @@
+package prpl
+
+import (
+	"testing"
+
+	"net/http"
+)
+
+// newTestServer creates a prpl instance serving the test app
+func newTestServer(t *testing.T, options ...optionFn) *prpl {
+	options = append([]optionFn{
+		WithRoot(http.Dir("testdata/app")),
+		WithConfigFile("testdata/app/polymer.json"),
+	}, options...)
+
+	p, err := New(options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// sharedFiles serves the files outside of the test builds
+// that their push manifests reference
+func sharedFiles() optionFn {
+	return WithStaticFiles(&StaticFiles{Allow: []string{"/shared/*"}})
+}
+
+func TestNewValidation(t *testing.T) {
+	options := []optionFn{
+		WithRoot(http.Dir("testdata/broken")),
+		WithConfigFile("testdata/broken/polymer.json"),
+	}
+
+	// lenient mode skips the builds that can't be served
+	p, err := New(options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.generation().builds) != 2 {
+		t.Fatalf("expected 2 builds: got %d", len(p.generation().builds))
+	}
+	for _, build := range p.generation().builds {
+		if build.template == nil {
+			t.Errorf("expected build %s to have a template", build.name)
+		}
+	}
+
+	// strict mode reports every problem
+	_, err = New(append(options, WithStrict(true))...)
+	report, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("expected validation errors: got %v", err)
+	}
+
+	tests := []struct {
+		build string
+		fatal bool
+	}{
+		{"noentry", true},
+		{"nomanifest", false},
+		{"", true},
+	}
+
+	if len(report) != len(tests) {
+		t.Fatalf("expected %d errors: got %v", len(tests), report)
+	}
+	for i, test := range tests {
+		if report[i].Build != test.build || report[i].Fatal != test.fatal {
+			t.Errorf("expected error for build %q (fatal %t): got %v", test.build, test.fatal, report[i])
+		}
+	}
+
+	// there must be at least one build
+	if _, err := New(WithRoot(http.Dir("testdata/broken/noentry")), WithConfig(&ProjectConfig{})); err == nil {
+		t.Error("expected error when no builds can be loaded")
+	}
+}
@@ -0,0 +1,241 @@
+package prpl
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"net/http"
+	"path/filepath"
+)
+
+// generation is a loaded set of builds and the handler serving
+// them. It is immutable once created so that in-flight requests
+// can continue to use it while a new generation is swapped in.
+type generation struct {
+	config      *ProjectConfig
+	builds      builds
+	report      ValidationErrors
+	handler     http.Handler
+	loaded      time.Time
+	fingerprint string
+}
+
+// ServeHTTP serves the request using the current generation
+func (p *prpl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.generation().handler.ServeHTTP(w, r)
+}
+
+// generation returns the current generation
+func (p *prpl) generation() *generation {
+	return p.current.Load().(*generation)
+}
+
+// load creates a new generation, re-reading the
+// configuration file if one is being used
+func (p *prpl) load() (*generation, error) {
+	config := p.config
+	if p.configFile != "" {
+		var err error
+		if config, err = ConfigFromFile(p.configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	// taken before the builds are read so that changes made
+	// while they are loading will trigger another reload
+	fingerprint := p.fingerprint(config)
+
+	routes, err := p.loadRoutes(config)
+	if err != nil {
+		return nil, err
+	}
+
+	builds, report, err := p.loadBuilds(config, routes)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &generation{
+		config:      config,
+		builds:      builds,
+		report:      report,
+		loaded:      time.Now(),
+		fingerprint: fingerprint,
+	}
+	g.handler = p.createHandler(g)
+
+	return g, nil
+}
+
+// loadRoutes merges the routes derived from the fragments, the routes
+// from the configuration, the routes file and the WithRoutes option,
+// with later sources taking precedence
+func (p *prpl) loadRoutes(config *ProjectConfig) (Routes, error) {
+	routes := Routes{}
+	if config != nil && p.fragmentRoutes {
+		routes = fragmentRoutes(config.Fragments, p.fragmentRoute)
+		logRoutes("Derived routes from fragments", routes)
+	}
+	if config != nil {
+		for pattern, fragment := range config.Routes {
+			routes[pattern] = fragment
+		}
+	}
+	if p.routesFile != "" {
+		fileRoutes, err := RoutesFromFile(p.routesFile)
+		if err != nil {
+			return nil, err
+		}
+		for pattern, fragment := range fileRoutes {
+			routes[pattern] = fragment
+		}
+	}
+	for pattern, fragment := range p.routes {
+		routes[pattern] = fragment
+	}
+	return routes, nil
+}
+
+// logRoutes logs the route table in order of precedence
+func logRoutes(title string, routes Routes) {
+	patterns := make([]string, 0, len(routes))
+	for pattern := range routes {
+		patterns = append(patterns, pattern)
+	}
+	table, err := compileRoutes(patterns)
+	if err != nil {
+		log.Printf("WARNING: %s: %v\n", strings.ToLower(title), err)
+		return
+	}
+
+	lines := make([]string, len(table))
+	for i, r := range table {
+		lines[i] = fmt.Sprintf("  %s -> %s", r.pattern, routes[r.pattern])
+	}
+	log.Printf("%s:\n%s\n", title, strings.Join(lines, "\n"))
+}
+
+// Reload loads the builds again and atomically swaps them in if
+// every build can be loaded, otherwise the previous builds continue
+// to be served and the validation errors are returned. The builds
+// are not swapped if the context is done before they are loaded.
+func (p *prpl) Reload(ctx context.Context) error {
+	_, err := p.reload(ctx)
+	return err
+}
+
+// reload loads a new generation and swaps it in if it is valid,
+// otherwise the previous generation continues to be served
+func (p *prpl) reload(ctx context.Context) (*generation, error) {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	g, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// builds would be dropped so keep serving the working ones
+	if g.report.fatal() {
+		return nil, g.report
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.current.Store(g)
+	return g, nil
+}
+
+// watch polls the configuration, routes, push manifest and entrypoint files
+// and reloads the builds when they change. A change has to be stable
+// for one interval before it is loaded to avoid partial deploys.
+func (p *prpl) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := p.generation().fingerprint
+	pending := ""
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		current := p.fingerprint(p.generation().config)
+		if current == last {
+			pending = ""
+			continue
+		}
+		if current != pending {
+			pending = current
+			continue
+		}
+
+		last, pending = current, ""
+		if g, err := p.reload(context.Background()); err != nil {
+			log.Printf("WARNING: reload failed, keeping previous builds: %v\n", err)
+		} else {
+			log.Println("Reloaded builds")
+			last = g.fingerprint
+		}
+	}
+}
+
+// fingerprint returns the size and modification time of every
+// file that should trigger a reload when it changes
+func (p *prpl) fingerprint(config *ProjectConfig) string {
+	entrypoint := "index.html"
+	if config != nil && config.Entrypoint != "" {
+		entrypoint = config.Entrypoint
+	}
+
+	filenames := []string{}
+	if p.configFile != "" {
+		filenames = append(filenames, p.configFile)
+	}
+	if p.routesFile != "" {
+		filenames = append(filenames, p.routesFile)
+	}
+	if config == nil || len(config.Builds) == 0 {
+		filenames = append(filenames,
+			filepath.Join(string(p.root), entrypoint),
+			filepath.Join(string(p.root), "push-manifest.json"),
+		)
+	} else {
+		for _, build := range config.Builds {
+			filenames = append(filenames,
+				filepath.Join(string(p.root), build.Name, entrypoint),
+				filepath.Join(string(p.root), build.Name, "push-manifest.json"),
+			)
+		}
+	}
+
+	parts := make([]string, len(filenames))
+	for i, filename := range filenames {
+		if info, err := os.Stat(filename); err == nil {
+			parts[i] = fmt.Sprintf("%s:%d:%d", filename, info.Size(), info.ModTime().UnixNano())
+		} else {
+			parts[i] = filename + ":-"
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// Close stops watching for changes to the builds
+func (p *prpl) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	return nil
+}
@@ -0,0 +1,113 @@
+package prpl
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"net/http"
+	"path/filepath"
+)
+
+// copyDir copies the test fixture at src into a temporary directory
+func copyDir(t *testing.T, src string) string {
+	dir, err := ioutil.TempDir("", "prpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, rel), 0755)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dir, rel), data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// waitFor polls the handler until the body contains expect
+func waitFor(t *testing.T, h http.Handler, expect string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if strings.Contains(get(h, "/", "unknown browser").Body.String(), expect) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %q", expect)
+}
+
+func TestWatch(t *testing.T) {
+	dir := copyDir(t, "testdata/other")
+	defer os.RemoveAll(dir)
+
+	p, err := New(
+		WithRoot(http.Dir(dir)),
+		WithConfigFile(filepath.Join(dir, "polymer.json")),
+		WithWatch(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	waitFor(t, p, "<body>other</body>")
+
+	// changing the entrypoint swaps in the new build
+	entrypoint := filepath.Join(dir, "modern", "index.html")
+	if err := ioutil.WriteFile(entrypoint, []byte("<html><body>updated</body></html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, p, "<body>updated</body>")
+
+	// adding a build to the config that can't be loaded keeps the previous builds
+	config := `{"builds": [{"name": "modern"}, {"name": "missing"}]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "polymer.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(context.Background()); err == nil {
+		t.Error("expected reload to fail")
+	}
+	if builds := p.generation().builds; len(builds) != 1 || builds[0].name != "modern" {
+		t.Errorf("expected previous build to be kept: got %d builds", len(builds))
+	}
+	waitFor(t, p, "<body>updated</body>")
+}
+
+func TestWatchChangeBeforeStart(t *testing.T) {
+	dir := copyDir(t, "testdata/other")
+	defer os.RemoveAll(dir)
+
+	p, err := New(
+		WithRoot(http.Dir(dir)),
+		WithConfigFile(filepath.Join(dir, "polymer.json")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// a deploy after the builds are loaded but before watching starts
+	entrypoint := filepath.Join(dir, "modern", "index.html")
+	if err := ioutil.WriteFile(entrypoint, []byte("<html><body>deployed</body></html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	go p.watch(10 * time.Millisecond)
+	waitFor(t, p, "<body>deployed</body>")
+}
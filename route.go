@@ -0,0 +1,207 @@
+package prpl
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type (
+	// route is a compiled route pattern
+	route struct {
+		pattern  string
+		segments []string
+		re       *regexp.Regexp
+	}
+
+	// routeTable is a set of compiled routes in order of precedence
+	routeTable []*route
+
+	// FragmentRouteFn returns the url pattern for a fragment, or an
+	// empty string if the fragment shouldn't be routed
+	FragmentRouteFn func(fragment string) string
+)
+
+// segment kinds in order of precedence
+const (
+	segmentWildcard = iota
+	segmentParam
+	segmentStatic
+)
+
+// compileRoutes compiles route patterns so they can be matched
+// against request paths. Patterns are one of:
+//
+//	/view1       an exact path
+//	/users/:id   a parameter matching a single path segment
+//	/docs/*      a wildcard matching the rest of the path
+//	~^/\d+$      a regular expression
+//
+// Exact paths take precedence, then parameter and wildcard patterns
+// compared segment by segment, with static segments before parameters
+// before wildcards and longer patterns first. Regular expressions are
+// tried last. Remaining ties are broken by the pattern text so the
+// order never depends on map iteration.
+func compileRoutes(patterns []string) (routeTable, error) {
+	table := make(routeTable, 0, len(patterns))
+	for _, pattern := range patterns {
+		r, err := compileRoute(pattern)
+		if err != nil {
+			return nil, err
+		}
+		table = append(table, r)
+	}
+	sort.Sort(byPrecedence(table))
+	return table, nil
+}
+
+func compileRoute(pattern string) (*route, error) {
+	if strings.HasPrefix(pattern, "~") {
+		re, err := regexp.Compile(pattern[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %q: %v", pattern, err)
+		}
+		return &route{pattern: pattern, re: re}, nil
+	}
+
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("invalid route %q, must start with / or ~", pattern)
+	}
+
+	segments := strings.Split(pattern[1:], "/")
+	for i, segment := range segments {
+		switch {
+		case segment == "*" && i != len(segments)-1:
+			return nil, fmt.Errorf("invalid route %q, * must be the last segment", pattern)
+		case segment == ":":
+			return nil, fmt.Errorf("invalid route %q, parameter has no name", pattern)
+		}
+	}
+
+	return &route{pattern: pattern, segments: segments}, nil
+}
+
+// match returns the pattern of the first route matching the path
+func (t routeTable) match(path string) (string, bool) {
+	for _, r := range t {
+		if r.match(path) {
+			return r.pattern, true
+		}
+	}
+	return "", false
+}
+
+func (r *route) match(path string) bool {
+	if r.re != nil {
+		return r.re.MatchString(path)
+	}
+	if r.exact() {
+		return path == r.pattern
+	}
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+
+	parts := strings.Split(path[1:], "/")
+	for i, segment := range r.segments {
+		if i >= len(parts) {
+			return false
+		}
+		switch segmentKind(segment) {
+		case segmentWildcard:
+			return true
+		case segmentParam:
+			if parts[i] == "" {
+				return false
+			}
+		default:
+			if parts[i] != segment {
+				return false
+			}
+		}
+	}
+	return len(parts) == len(r.segments)
+}
+
+// exact is true if the route has no parameters or wildcards
+func (r *route) exact() bool {
+	if r.re != nil {
+		return false
+	}
+	for _, segment := range r.segments {
+		if segmentKind(segment) != segmentStatic {
+			return false
+		}
+	}
+	return true
+}
+
+func segmentKind(segment string) int {
+	switch {
+	case segment == "*":
+		return segmentWildcard
+	case strings.HasPrefix(segment, ":"):
+		return segmentParam
+	}
+	return segmentStatic
+}
+
+type byPrecedence routeTable
+
+func (a byPrecedence) Len() int      { return len(a) }
+func (a byPrecedence) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byPrecedence) Less(i, j int) bool {
+	ri, rj := a[i], a[j]
+
+	// regular expressions last
+	if (ri.re == nil) != (rj.re == nil) {
+		return ri.re == nil
+	}
+
+	// exact paths first
+	if ri.exact() != rj.exact() {
+		return ri.exact()
+	}
+
+	if ri.re == nil && !ri.exact() {
+		for k := 0; k < len(ri.segments) && k < len(rj.segments); k++ {
+			ki, kj := segmentKind(ri.segments[k]), segmentKind(rj.segments[k])
+			if ki != kj {
+				return ki > kj
+			}
+		}
+		if len(ri.segments) != len(rj.segments) {
+			return len(ri.segments) > len(rj.segments)
+		}
+	}
+
+	return ri.pattern < rj.pattern
+}
+
+// DefaultFragmentRoute names the route for a fragment after its
+// element, without the element prefix that custom element names
+// require, e.g. src/my-view1.html is routed from /view1
+func DefaultFragmentRoute(fragment string) string {
+	name := path.Base(fragment)
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if i := strings.Index(name, "-"); i != -1 {
+		name = name[i+1:]
+	}
+	if name == "" {
+		return ""
+	}
+	return "/" + name
+}
+
+// fragmentRoutes derives the routes for the fragments
+func fragmentRoutes(fragments []string, name FragmentRouteFn) Routes {
+	routes := Routes{}
+	for _, fragment := range fragments {
+		if pattern := name(fragment); pattern != "" {
+			routes[pattern] = fragment
+		}
+	}
+	return routes
+}
@@ -0,0 +1,175 @@
+package prpl
+
+import (
+	"strings"
+	"testing"
+
+	"net/http"
+)
+
+func TestRoutes(t *testing.T) {
+	routes, err := compileRoutes([]string{
+		`~^/items/[0-9]+$`,
+		"/docs/*",
+		"/docs/api/*",
+		"/users/:id",
+		"/users/me",
+		"/users/:id/*",
+		"/:page",
+		"/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		expect string
+	}{
+		{"/", "/"},
+		{"/users/me", "/users/me"},
+		{"/users/123", "/users/:id"},
+		{"/users/123/posts/1", "/users/:id/*"},
+		{"/docs/", "/docs/*"},
+		{"/docs/intro", "/docs/*"},
+		{"/docs/api/new", "/docs/api/*"},
+		{"/docs", "/:page"},
+		{"/items/42", `~^/items/[0-9]+$`},
+		{"/items/new", ""},
+		{"/users/", ""},
+	}
+
+	for _, test := range tests {
+		pattern, _ := routes.match(test.path)
+		if pattern != test.expect {
+			t.Errorf("%s expected %q: got %q", test.path, test.expect, pattern)
+		}
+	}
+}
+
+func TestRoutesInvalid(t *testing.T) {
+	for _, pattern := range []string{"view1", "/docs/*/more", "/users/:", "~[a-"} {
+		if _, err := compileRoutes([]string{pattern}); err == nil {
+			t.Errorf("%s expected error", pattern)
+		}
+	}
+}
+
+func TestRoutePushHeaders(t *testing.T) {
+	p := newTestServer(t,
+		WithRoutes(Routes{
+			"/users/:id": "src/my-view1.html",
+			"/docs/*":    "src/my-view2.html",
+		}),
+	)
+
+	tests := []struct {
+		path   string
+		expect string
+	}{
+		{"/users/123", "fallback/src/my-view1.html>"},
+		{"/docs/intro/more", "fallback/src/my-view2.html>"},
+		{"/other", ""},
+	}
+
+	for _, test := range tests {
+		w := get(p, test.path, "unknown browser")
+		link := strings.Join(w.Header()["Link"], ", ")
+		if w.Code != http.StatusOK {
+			t.Errorf("%s expected status 200: got %d", test.path, w.Code)
+		}
+		if test.expect == "" && link != "" || !strings.Contains(link, test.expect) {
+			t.Errorf("%s expected link to contain %q: got %s", test.path, test.expect, link)
+		}
+	}
+}
+
+func TestRouteSources(t *testing.T) {
+	config, err := ConfigFromFile("testdata/app/polymer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Routes = Routes{
+		"/view1":     "src/my-view1.html",
+		"/users/:id": "src/my-view1.html",
+	}
+
+	// the routes file overrides the config and WithRoutes overrides both
+	p, err := New(
+		WithRoot(http.Dir("testdata/app")),
+		WithConfig(config),
+		WithRoutesFile("testdata/routes.json"),
+		WithRoutes(Routes{"/view2": "src/my-view1.html"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		expect string
+	}{
+		{"/view1", "fallback/src/my-view1.html>"},
+		{"/users/123", "fallback/src/my-view2.html>"},
+		{"/view2", "fallback/src/my-view1.html>"},
+	}
+
+	for _, test := range tests {
+		w := get(p, test.path, "unknown browser")
+		if link := strings.Join(w.Header()["Link"], ", "); !strings.Contains(link, test.expect) {
+			t.Errorf("%s expected link to contain %q: got %s", test.path, test.expect, link)
+		}
+	}
+
+	if _, err := New(WithRoutesFile("testdata/missing.json")); err == nil {
+		t.Error("expected error for missing routes file")
+	}
+}
+
+func TestFragmentRoutes(t *testing.T) {
+	tests := []struct {
+		fragment string
+		expect   string
+	}{
+		{"src/my-view1.html", "/view1"},
+		{"src/my-app-view.html", "/app-view"},
+		{"src/about.html", "/about"},
+		{"src/my-.html", ""},
+	}
+
+	for _, test := range tests {
+		if route := DefaultFragmentRoute(test.fragment); route != test.expect {
+			t.Errorf("%s expected %q: got %q", test.fragment, test.expect, route)
+		}
+	}
+
+	// explicit routes take precedence over derived routes
+	p := newTestServer(t,
+		WithFragmentRoutes(true),
+		WithRoutes(Routes{"/view2": "src/my-view1.html"}),
+	)
+	for path, expect := range map[string]string{
+		"/view1": "fallback/src/my-view1.html>",
+		"/view2": "fallback/src/my-view1.html>",
+	} {
+		w := get(p, path, "unknown browser")
+		if link := strings.Join(w.Header()["Link"], ", "); !strings.Contains(link, expect) {
+			t.Errorf("%s expected link to contain %q: got %s", path, expect, link)
+		}
+	}
+
+	p = newTestServer(t,
+		WithFragmentRoutes(true),
+		WithFragmentRouteName(func(fragment string) string {
+			return "/pages" + DefaultFragmentRoute(fragment)
+		}),
+	)
+	w := get(p, "/pages/view2", "unknown browser")
+	if link := strings.Join(w.Header()["Link"], ", "); !strings.Contains(link, "fallback/src/my-view2.html>") {
+		t.Errorf("expected link to contain my-view2: got %s", link)
+	}
+	w = get(p, "/view2", "unknown browser")
+	if link := strings.Join(w.Header()["Link"], ", "); strings.Contains(link, "my-view2") {
+		t.Errorf("expected no link to my-view2: got %s", link)
+	}
+}
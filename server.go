@@ -2,65 +2,125 @@ package prpl
 
 import (
 	"bytes"
+	"path"
 	"strings"
+	"time"
 
 	"net/http"
 )
 
-func (p *prpl) createHandler() http.Handler {
+func (p *prpl) createHandler(g *generation) http.Handler {
 	m := http.NewServeMux()
 
 	for path, handler := range p.staticHandlers {
 		m.Handle(path, handler)
 	}
 
-	for _, build := range p.builds {
-		m.HandleFunc(build.entrypoint, p.routeHandler)
-		m.Handle(build.name, http.StripPrefix(build.name, p.staticHandler(http.FileServer(p.root))))
+	// build paths are prefixed with the version if set
+	prefix := ""
+	if p.version != "" {
+		prefix = "/" + p.version
 	}
 
-	m.HandleFunc("/", p.routeHandler)
+	routeHandler := p.routeHandler(g.builds)
+	var rootBuild http.Handler
+	for _, build := range g.builds {
+		m.Handle(prefix+"/"+build.entrypoint, routeHandler)
+		if build.name != "" {
+			handler := p.staticHandler(build)
+			m.Handle(prefix+"/"+build.name+"/", http.StripPrefix(prefix, handler))
+		} else {
+			rootBuild = p.staticHandler(build)
+		}
+	}
+
+	m.Handle("/", p.rootHandler(g.builds, routeHandler, rootBuild))
 
 	return m
 }
 
-func (p *prpl) routeHandler(w http.ResponseWriter, r *http.Request) {
-	capabilities := p.browserCapabilities(r.UserAgent())
-	build := p.builds.findBuild(capabilities)
-	if build == nil {
-		http.Error(w, "This browser is not supported", http.StatusInternalServerError)
-		return
+// rootHandler serves the static files and the entrypoint for any other
+// path without a file extension. Other paths are served by the build
+// in the root, if there are no named builds, or are not found.
+func (p *prpl) rootHandler(builds builds, routeHandler, rootBuild http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if p.staticFiles != nil && r.URL.Path != "/" && p.serveStatic(w, r) {
+			return
+		}
+		if path.Ext(r.URL.Path) != "" {
+			if rootBuild != nil && !hasDotSegment(r.URL.Path) {
+				rootBuild.ServeHTTP(w, r)
+				return
+			}
+			p.setDetectHeaders(w.Header())
+			p.notFound(builds.findBuild(p.detectCapabilities(r)), w, r)
+			return
+		}
+		routeHandler.ServeHTTP(w, r)
 	}
 
-	h := w.Header()
-	h.Set("Cache-Control", "public, max-age=0")
-	if p.usePush {
-		build.addPushHeaders(w, h, r.URL.Path)
+	return http.HandlerFunc(fn)
+}
+
+func (p *prpl) routeHandler(builds builds) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		p.setDetectHeaders(h)
+
+		capabilities := p.detectCapabilities(r)
+		build := builds.findBuild(capabilities)
+		if build == nil {
+			http.Error(w, "This browser is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		h.Set("Cache-Control", "public, max-age=0")
+		resources := build.routeResources(r.URL.Path)
+		if p.earlyHints {
+			sendEarlyHints(w, resources)
+		}
+		if p.pushMode != PushNone {
+			addPushHeaders(w, p.pushMode, resources)
+		}
+		build.template.Render(w, r)
 	}
-	build.template.Render(w, r)
+
+	return http.HandlerFunc(fn)
 }
 
-func (p *prpl) staticHandler(next http.Handler) http.Handler {
+func (p *prpl) staticHandler(build *build) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		// TODO: Service worker location should be configurable.
+		file, found := build.files[strings.TrimPrefix(r.URL.Path, "/")]
+		if !found && !p.isFile(r.URL.Path) {
+			p.notFound(build, w, r)
+			return
+		}
+
 		h := w.Header()
-		if strings.HasSuffix(r.URL.Path, "service-worker.js") {
+		if isServiceWorker(r.URL.Path) {
 			h.Set("Service-Worker-Allowed", "/")
-			h.Set("Cache-Control", "private, max-age=0")
-		} else {
-			h.Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		h.Set("Cache-Control", cacheControl(r.URL.Path))
+
+		// static documents are requested using the unversioned path
+		if p.earlyHints {
+			url := r.URL.Path
+			if p.version != "" {
+				url = "/" + p.version + url
+			}
+			sendEarlyHints(w, build.documentHeaders[url])
 		}
 
-		file, found := files[r.URL.Path]
 		if !found {
-			next.ServeHTTP(w, r)
+			p.serveFile(w, r, p.root, r.URL.Path)
 			return
 		}
 
 		// TODO: if using original prpl-server-node strategy
 		// add the push headers for *this* push-manifest entry
-		// build.addPushHeaders(w, h, r.URL.Path)
+		// addPushHeaders(w, p.pushMode, build.documentHeaders[url])
 
+		h.Set("ETag", file.etag)
 		content := bytes.NewReader(file.data)
 		http.ServeContent(w, r, r.URL.Path, file.modTime, content)
 	}
@@ -68,24 +128,137 @@ func (p *prpl) staticHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-func (b *build) addPushHeaders(w http.ResponseWriter, header http.Header, filename string) {
-	if links, ok := b.pushHeaders[filename]; ok {
-		// TODO: use actual push if server supports it
-		// need to add content type to push header info
-		// if pusher, ok := w.(http.Pusher); ok {
-		// 	for _, url := range links {
-		// 		pusher.Push(url, &http.PushOptions{
-		// 			Header: http.Header{
-		// 				"Cache-Control": []string{"public, max-age=31536000, immutable"},
-		// 				"Content-Type":  []string{"TODO: file content type here"},
-		// 			},
-		// 		})
-		// 	}
-		// } else {
-		// otherwise hope there is a proxy that will do it for us
-		for _, link := range links {
-			header.Add("Link", link)
+// notFound serves the not found page for the build if there is one,
+// otherwise the not found handler
+func (p *prpl) notFound(build *build, w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Cache-Control", "public, max-age=0")
+
+	if build != nil && build.notFoundPage != nil {
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		if r.Method != http.MethodHead {
+			w.Write(build.notFoundPage)
+		}
+		return
+	}
+
+	p.notFoundHandler.ServeHTTP(w, r)
+}
+
+// setDetectHeaders asks for the client hints used to detect
+// capabilities and marks the response as varying by them
+func (p *prpl) setDetectHeaders(h http.Header) {
+	if p.acceptCH != "" {
+		h.Set("Accept-CH", p.acceptCH)
+	}
+	if p.vary != "" {
+		h.Add("Vary", p.vary)
+	}
+}
+
+// isFile is true if the path is a regular file in the root
+func (p *prpl) isFile(name string) bool {
+	f, err := p.root.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
+
+// TODO: Service worker location should be configurable.
+func isServiceWorker(filename string) bool {
+	return strings.HasSuffix(filename, "service-worker.js")
+}
+
+// cacheControl returns the Cache-Control header for a static file
+func cacheControl(filename string) string {
+	if isServiceWorker(filename) {
+		return "private, max-age=0"
+	}
+	return "public, max-age=31536000, immutable"
+}
+
+// routeResources returns the push resources for the route matching the path
+func (b *build) routeResources(path string) []*pushResource {
+	pattern, ok := b.routes.match(path)
+	if !ok {
+		return nil
+	}
+	return b.pushHeaders[pattern]
+}
+
+// sendEarlyHints sends a 103 Early Hints response with link preload
+// headers for the resources so the browser can start fetching them
+// while the response is being prepared. The link headers are removed
+// again afterwards so the push mode controls those in the response.
+func sendEarlyHints(w http.ResponseWriter, resources []*pushResource) {
+	if len(resources) == 0 {
+		return
+	}
+
+	w = earlyHintsWriter(w)
+	if w == nil {
+		return
+	}
+
+	header := w.Header()
+	links := header["Link"]
+	for _, resource := range resources {
+		header.Add("Link", resource.link)
+	}
+	w.WriteHeader(http.StatusEarlyHints)
+
+	if links == nil {
+		header.Del("Link")
+	} else {
+		header["Link"] = links
+	}
+}
+
+// earlyHintsWriter unwraps middleware writers, the same way as
+// http.ResponseController, to the server's own writer which can send
+// informational responses. Middleware that records the first status
+// written would otherwise take the 103 for the final status. It returns
+// nil if the writer isn't known to support informational responses.
+func earlyHintsWriter(w http.ResponseWriter) http.ResponseWriter {
+	for {
+		switch rw := w.(type) {
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		case interface{ SetWriteDeadline(time.Time) error }:
+			return w
+		default:
+			return nil
+		}
+	}
+}
+
+// addPushHeaders pushes the resources using http.Pusher if requested
+// and supported, otherwise it adds link preload headers and hopes
+// there is a proxy that will push them for us
+func addPushHeaders(w http.ResponseWriter, mode PushMode, resources []*pushResource) {
+
+	pusher, canPush := w.(http.Pusher)
+	canPush = canPush && mode&PushNative == PushNative
+
+	header := w.Header()
+	for _, resource := range resources {
+		pushed := false
+		if canPush {
+			opts := &http.PushOptions{Header: resource.header}
+			if err := pusher.Push(resource.url, opts); err == nil {
+				pushed = true
+			} else if err == http.ErrNotSupported {
+				// client has disabled push so don't try again
+				canPush = false
+			}
+		}
+		if !pushed || mode&PushLink == PushLink {
+			header.Add("Link", resource.link)
 		}
-		// }
 	}
 }
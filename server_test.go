@@ -0,0 +1,303 @@
+package prpl
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+)
+
+// get requests path from the handler with the user agent
+func get(h http.Handler, path, userAgent string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	r.Header.Set("User-Agent", userAgent)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestInstancesAreIndependent(t *testing.T) {
+	app := newTestServer(t)
+	other, err := New(
+		WithRoot(http.Dir("testdata/other")),
+		WithConfigFile("testdata/other/polymer.json"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		h      http.Handler
+		path   string
+		expect string
+	}{
+		{app, "/", "<my-app>fallback</my-app>"},
+		{app, "/view1", "<my-app>fallback</my-app>"},
+		{app, "/modern/src/my-view1.js", "// modern my-view1.js"},
+		{other, "/", "<body>other</body>"},
+		{other, "/view1", "<body>other</body>"},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, test := range tests {
+			wg.Add(1)
+			go func(h http.Handler, path, expect string) {
+				defer wg.Done()
+				w := get(h, path, "unknown browser")
+				if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), expect) {
+					t.Errorf("expected %s to contain %q: got %d %s", path, expect, w.Code, w.Body.String())
+				}
+			}(test.h, test.path, test.expect)
+		}
+	}
+	wg.Wait()
+}
+
+func TestVersion(t *testing.T) {
+	p := newTestServer(t,
+		WithVersion("20170806"),
+		WithRoutes(Routes{"/view1": "src/my-view1.html"}),
+	)
+
+	w := get(p, "/view1", "unknown browser")
+	if body := w.Body.String(); !strings.Contains(body, `<base href="/20170806/fallback/">`) {
+		t.Errorf("expected base href to include version: got %s", body)
+	}
+	links := strings.Join(w.Header()["Link"], "\n")
+	if !strings.Contains(links, "20170806/fallback/src/my-view1.html>") {
+		t.Errorf("expected link headers to include version: got %s", links)
+	}
+
+	w = get(p, "/20170806/modern/src/my-view1.js", "unknown browser")
+	if body := w.Body.String(); w.Code != http.StatusOK || body != "// modern my-view1.js\n" {
+		t.Errorf("expected versioned static file: got %d %s", w.Code, body)
+	}
+}
+
+func TestEarlyHints(t *testing.T) {
+	for _, version := range []string{"", "v1"} {
+		p := newTestServer(t,
+			WithVersion(version),
+			WithRoutes(Routes{"/view1": "src/my-view1.html"}),
+			WithEarlyHints(true),
+			WithPush(PushNone),
+			sharedFiles(),
+		)
+		s := httptest.NewServer(p)
+		defer s.Close()
+
+		base := "/fallback/"
+		if version != "" {
+			base = "/" + version + base
+		}
+
+		tests := []struct {
+			path   string
+			expect []string
+		}{
+			{"/view1", []string{
+				"<" + base + "bower_components/webcomponentsjs/webcomponents-loader.js>; rel=preload; as=script",
+				"<" + base + "src/my-app.html>; rel=preload; as=document",
+				"<" + base + "src/shared-styles.html>; rel=preload; as=document",
+				"</shared/logo.png>; rel=preload; as=image",
+				"<" + base + "src/my-view1.html>; rel=preload; as=document",
+				"<" + base + "src/my-view1.js>; rel=preload; as=script",
+			}},
+			{base + "src/my-view1.html", []string{
+				"<" + base + "src/my-view1.js>; rel=preload; as=script",
+			}},
+			{"/view2", nil},
+			{base + "src/my-view1.js", nil},
+		}
+
+		for _, test := range tests {
+			var hints []string
+			trace := &httptrace.ClientTrace{
+				Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+					if code == http.StatusEarlyHints {
+						hints = append(hints, header["Link"]...)
+					}
+					return nil
+				},
+			}
+
+			r, _ := http.NewRequest("GET", s.URL+test.path, nil)
+			r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+			w, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Body.Close()
+
+			if w.StatusCode != http.StatusOK {
+				t.Errorf("%s expected status 200: got %d", test.path, w.StatusCode)
+			}
+			if strings.Join(hints, "\n") != strings.Join(test.expect, "\n") {
+				t.Errorf("%s expected early hints:\n%s\ngot:\n%s", test.path, strings.Join(test.expect, "\n"), strings.Join(hints, "\n"))
+			}
+			// the push mode controls the link headers in the response
+			if links := w.Header["Link"]; len(links) != 0 {
+				t.Errorf("%s expected no link headers: got %v", test.path, links)
+			}
+		}
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/60.0.3112.113 Safari/537.36"
+
+	p := newTestServer(t)
+	custom := newTestServer(t,
+		WithNotFoundPage("404.html"),
+		WithNotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "custom not found", http.StatusNotFound)
+		})),
+	)
+
+	tests := []struct {
+		h         http.Handler
+		path      string
+		userAgent string
+		code      int
+		expect    string
+	}{
+		{p, "/view1", "", http.StatusOK, "<my-app>fallback</my-app>"},
+		{p, "/users/1.5/", "", http.StatusOK, "<my-app>fallback</my-app>"},
+		{p, "/shared/logo.png", "", http.StatusNotFound, "404 page not found"},
+		{p, "/missing.js", "", http.StatusNotFound, "404 page not found"},
+		{p, "/favicon.ico", "", http.StatusNotFound, "404 page not found"},
+		{p, "/modern/missing.js", "", http.StatusNotFound, "404 page not found"},
+		{p, "/modern/src/", "", http.StatusNotFound, "404 page not found"},
+		{p, "/modern/src/my-view1.js", "", http.StatusOK, "// modern my-view1.js"},
+
+		// the page from the best build is used, otherwise the handler
+		{custom, "/missing.js", chrome, http.StatusNotFound, "<p>modern not found</p>"},
+		{custom, "/missing.js", "unknown browser", http.StatusNotFound, "custom not found"},
+		{custom, "/modern/missing.js", "unknown browser", http.StatusNotFound, "<p>modern not found</p>"},
+		{custom, "/fallback/missing.js", chrome, http.StatusNotFound, "custom not found"},
+	}
+
+	for _, test := range tests {
+		w := get(test.h, test.path, test.userAgent)
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.expect) {
+			t.Errorf("%s expected %d %q: got %d %s", test.path, test.code, test.expect, w.Code, w.Body.String())
+		}
+		if w.Code == http.StatusNotFound && w.Header().Get("Cache-Control") != "public, max-age=0" {
+			t.Errorf("%s expected not found not to be cached: got %s", test.path, w.Header().Get("Cache-Control"))
+		}
+	}
+}
+
+func TestNoBuilds(t *testing.T) {
+	p, err := New(
+		WithRoot(http.Dir("testdata/single")),
+		WithConfig(&ProjectConfig{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		code   int
+		expect string
+	}{
+		{"/", http.StatusOK, "<body>single</body>"},
+		{"/view1", http.StatusOK, "<body>single</body>"},
+		{"/app.js", http.StatusOK, "// single app.js"},
+		{"/missing.js", http.StatusNotFound, "404 page not found"},
+		{"/.secret", http.StatusNotFound, "404 page not found"},
+	}
+
+	for _, test := range tests {
+		w := get(p, test.path, "unknown browser")
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.expect) {
+			t.Errorf("%s expected %d %q: got %d %s", test.path, test.code, test.expect, w.Code, w.Body.String())
+		}
+	}
+}
+
+// pushRecorder is a response recorder that supports http.Pusher,
+// failing pushes of the targets in errs with the error given
+type pushRecorder struct {
+	*httptest.ResponseRecorder
+	errs    map[string]error
+	targets []string
+	headers []http.Header
+}
+
+func (w *pushRecorder) Push(target string, opts *http.PushOptions) error {
+	w.targets = append(w.targets, target)
+	w.headers = append(w.headers, opts.Header)
+	return w.errs[target]
+}
+
+func TestAddPushHeaders(t *testing.T) {
+	resources := []*pushResource{
+		newPushResource("/modern/src/app.js", "script"),
+		newPushResource("/modern/src/style.css", "style"),
+		newPushResource("/modern/src/my-view1.js", "script"),
+	}
+	links := make([]string, len(resources))
+	for i, resource := range resources {
+		links[i] = resource.link
+	}
+
+	tests := []struct {
+		name   string
+		mode   PushMode
+		pusher bool
+		errs   map[string]error
+		pushed []string
+		links  []string
+	}{
+		{"link", PushLink, true, nil, nil, links},
+		{"native", PushNative, true, nil, []string{"/modern/src/app.js", "/modern/src/style.css", "/modern/src/my-view1.js"}, nil},
+		{"both", PushBoth, true, nil, []string{"/modern/src/app.js", "/modern/src/style.css", "/modern/src/my-view1.js"}, links},
+
+		// falls back to link headers when push isn't available
+		{"no pusher", PushNative, false, nil, nil, links},
+		{
+			"not supported", PushNative, true,
+			map[string]error{"/modern/src/app.js": http.ErrNotSupported},
+			[]string{"/modern/src/app.js"},
+			links,
+		},
+		{
+			"push failed", PushNative, true,
+			map[string]error{"/modern/src/style.css": errors.New("failed")},
+			[]string{"/modern/src/app.js", "/modern/src/style.css", "/modern/src/my-view1.js"},
+			links[1:2],
+		},
+	}
+
+	for _, test := range tests {
+		recorder := &pushRecorder{ResponseRecorder: httptest.NewRecorder(), errs: test.errs}
+		var w http.ResponseWriter = recorder.ResponseRecorder
+		if test.pusher {
+			w = recorder
+		}
+		addPushHeaders(w, test.mode, resources)
+
+		if !reflect.DeepEqual(recorder.targets, test.pushed) {
+			t.Errorf("%s expected pushes %v: got %v", test.name, test.pushed, recorder.targets)
+		}
+		for i, header := range recorder.headers {
+			for _, key := range []string{"Content-Type", "Cache-Control"} {
+				if expect := resources[i].header.Get(key); expect == "" || header.Get(key) != expect {
+					t.Errorf("%s expected push of %s to have %s %q: got %q", test.name, recorder.targets[i], key, expect, header.Get(key))
+				}
+			}
+		}
+		if link := recorder.Header()["Link"]; !reflect.DeepEqual(link, test.links) {
+			t.Errorf("%s expected links %v: got %v", test.name, test.links, link)
+		}
+	}
+}
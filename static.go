@@ -0,0 +1,159 @@
+package prpl
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"net/http"
+)
+
+type (
+	// StaticFiles configures the files served from outside of the
+	// builds, such as robots.txt, manifest.json and favicon.ico.
+	// Globs without a / match the file name, otherwise the url path.
+	// Paths with a segment starting with a dot are only served if an
+	// allow glob names the dot segment too, such as /.well-known/*.
+	StaticFiles struct {
+		// Root is the directory to serve, the server root by default
+		Root http.Dir
+
+		// Allow lists the files that can be served. It is required
+		// for the server root, otherwise every file in Root is allowed
+		Allow []string
+
+		// Deny lists the files that can't be served even if they
+		// are allowed, the configuration files by default
+		Deny []string
+
+		// Cache sets the Cache-Control header using the first rule
+		// that matches, "public, max-age=0" if none match
+		Cache []CacheRule
+	}
+
+	// CacheRule sets the Cache-Control header for matching files
+	CacheRule struct {
+		Pattern      string
+		CacheControl string
+	}
+)
+
+var (
+	defaultStaticDeny  = []string{"polymer.json", "push-manifest.json"}
+	defaultStaticCache = "public, max-age=0"
+)
+
+// newStaticFiles applies the defaults and validates the globs
+func newStaticFiles(config *StaticFiles, root http.Dir) (*StaticFiles, error) {
+	s := *config
+	if s.Root == "" {
+		if len(s.Allow) == 0 {
+			return nil, fmt.Errorf("static files from the server root must have an allow list")
+		}
+		s.Root = root
+	}
+	if s.Deny == nil {
+		s.Deny = defaultStaticDeny
+	}
+
+	patterns := append(append([]string{}, s.Allow...), s.Deny...)
+	for _, rule := range s.Cache {
+		patterns = append(patterns, rule.Pattern)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid static file pattern %q: %v", pattern, err)
+		}
+	}
+
+	return &s, nil
+}
+
+// allowed is true if the file can be served
+func (s *StaticFiles) allowed(name string) bool {
+	allow := s.Allow
+	if hasDotSegment(name) {
+		allow = dotPatterns(s.Allow)
+		if len(allow) == 0 {
+			return false
+		}
+	}
+	if len(allow) > 0 && !matchAny(allow, name) {
+		return false
+	}
+	return !matchAny(s.Deny, name)
+}
+
+// dotPatterns returns the patterns that name a dot segment
+func dotPatterns(patterns []string) []string {
+	dot := []string{}
+	for _, pattern := range patterns {
+		if hasDotSegment(pattern) {
+			dot = append(dot, pattern)
+		}
+	}
+	return dot
+}
+
+// cacheControl returns the Cache-Control header for the file
+func (s *StaticFiles) cacheControl(name string) string {
+	for _, rule := range s.Cache {
+		if matchGlob(rule.Pattern, name) {
+			return rule.CacheControl
+		}
+	}
+	return defaultStaticCache
+}
+
+// serveStatic serves the static file if it is allowed and exists,
+// it returns false if the request hasn't been handled
+func (p *prpl) serveStatic(w http.ResponseWriter, r *http.Request) bool {
+	s := p.staticFiles
+	name := r.URL.Path
+	if !s.allowed(name) {
+		return false
+	}
+
+	f, err := s.Root.Open(name)
+	if err != nil {
+		return false
+	}
+	info, err := f.Stat()
+	f.Close()
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	w.Header().Set("Cache-Control", s.cacheControl(name))
+	return p.serveFile(w, r, s.Root, name)
+}
+
+// hasDotSegment is true if any segment of the path starts with a
+// dot, such as /.env or /.git/config
+func hasDotSegment(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches the file name if the pattern has no /,
+// otherwise the full path
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
@@ -0,0 +1,85 @@
+package prpl
+
+import (
+	"testing"
+
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestStaticFiles(t *testing.T) {
+	p := newTestServer(t,
+		WithStaticFiles(&StaticFiles{
+			Allow: []string{"*.txt", "*.json", "/shared/*"},
+			Cache: []CacheRule{
+				{"*.json", "public, max-age=3600"},
+				{"/shared/*", "public, max-age=86400"},
+			},
+		}),
+	)
+	allow := newTestServer(t, WithStaticFiles(&StaticFiles{Allow: []string{"/shared/*", "/.well-known/*"}}))
+	root := newTestServer(t, WithStaticFiles(&StaticFiles{Root: http.Dir("testdata/app/shared")}))
+	disabled := newTestServer(t)
+
+	tests := []struct {
+		h            http.Handler
+		path         string
+		code         int
+		cacheControl string
+	}{
+		{p, "/robots.txt", http.StatusOK, "public, max-age=0"},
+		{p, "/manifest.json", http.StatusOK, "public, max-age=3600"},
+		{p, "/shared/logo.png", http.StatusOK, "public, max-age=86400"},
+		{p, "/polymer.json", http.StatusNotFound, ""},
+		{p, "/.secret", http.StatusNotFound, ""},
+		{p, "/.hidden/config.txt", http.StatusNotFound, ""},
+		{allow, "/shared/logo.png", http.StatusOK, "public, max-age=0"},
+		{allow, "/robots.txt", http.StatusNotFound, ""},
+		{allow, "/.well-known/security.txt", http.StatusOK, "public, max-age=0"},
+		{p, "/.well-known/security.txt", http.StatusNotFound, ""},
+		{root, "/logo.png", http.StatusOK, "public, max-age=0"},
+		{root, "/robots.txt", http.StatusNotFound, ""},
+		{disabled, "/robots.txt", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		w := get(test.h, test.path, "unknown browser")
+		if w.Code != test.code {
+			t.Errorf("%s expected status %d: got %d", test.path, test.code, w.Code)
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		if cacheControl := w.Header().Get("Cache-Control"); cacheControl != test.cacheControl {
+			t.Errorf("%s expected Cache-Control %s: got %s", test.path, test.cacheControl, cacheControl)
+		}
+
+		etag := w.Header().Get("ETag")
+		if expect := contentETag(w.Body.Bytes()); etag != expect {
+			t.Errorf("%s expected content hash ETag %s: got %s", test.path, expect, etag)
+		}
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Header.Set("If-None-Match", etag)
+		rec := httptest.NewRecorder()
+		test.h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("%s expected status 304: got %d", test.path, rec.Code)
+		}
+	}
+
+	if _, err := New(
+		WithRoot(http.Dir("testdata/app")),
+		WithConfigFile("testdata/app/polymer.json"),
+		WithStaticFiles(&StaticFiles{Allow: []string{"*.txt"}, Deny: []string{"["}}),
+	); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+
+	if _, err := New(
+		WithRoot(http.Dir("testdata/app")),
+		WithConfigFile("testdata/app/polymer.json"),
+		WithStaticFiles(&StaticFiles{}),
+	); err == nil {
+		t.Error("expected error for server root without an allow list")
+	}
+}
@@ -2,8 +2,10 @@ package prpl
 
 import (
 	"bytes"
+	"log"
 	"time"
 
+	"html/template"
 	"net/http"
 )
 
@@ -16,20 +18,94 @@ type (
 		path    string
 		data    []byte
 		modTime time.Time
+
+		// variants are the compressed copies of the entrypoint, set
+		// when the builds are loaded, and etag is the strong ETag
+		// of the uncompressed content
+		variants []*variant
+		etag     string
 	}
 
-	createTemplateFn func(path string, data []byte, modTime time.Time) Template
+	// TemplateDataFn provides the per-request data used to render
+	// an entrypoint template, such as a CSP nonce, the user locale,
+	// feature flags or initial state
+	TemplateDataFn func(r *http.Request) (interface{}, error)
+
+	htmlTemplate struct {
+		path     string
+		template *template.Template
+		data     TemplateDataFn
+	}
+
+	createTemplateFn func(path string, data []byte, modTime time.Time) (Template, error)
 )
 
-func createDefaultTemplate(path string, data []byte, modTime time.Time) Template {
+func createDefaultTemplate(path string, data []byte, modTime time.Time) (Template, error) {
 	return &defaultTemplate{
 		path:    path,
 		data:    data,
 		modTime: modTime,
-	}
+		etag:    contentETag(data),
+	}, nil
 }
 
 func (t *defaultTemplate) Render(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	if len(t.variants) > 0 {
+		h.Add("Vary", "Accept-Encoding")
+		if v := negotiateVariant(r, t.variants); v != nil {
+			h.Set("Content-Encoding", v.encoding)
+			h.Set("ETag", v.etag)
+			http.ServeContent(w, r, t.path, t.modTime, bytes.NewReader(v.data))
+			return
+		}
+	}
+
+	h.Set("ETag", t.etag)
 	content := bytes.NewReader(t.data)
 	http.ServeContent(w, r, t.path, t.modTime, content)
 }
+
+// HTMLTemplate parses each entrypoint as an html/template when the
+// builds are loaded and renders it with the data returned by the
+// function for each request, or no data if it is nil. Use it with
+// WithRouteTemplate.
+func HTMLTemplate(data TemplateDataFn) createTemplateFn {
+	return func(path string, content []byte, modTime time.Time) (Template, error) {
+		tmpl, err := template.New(path).Parse(string(content))
+		if err != nil {
+			return nil, err
+		}
+
+		return &htmlTemplate{
+			path:     path,
+			template: tmpl,
+			data:     data,
+		}, nil
+	}
+}
+
+func (t *htmlTemplate) Render(w http.ResponseWriter, r *http.Request) {
+	var data interface{}
+	if t.data != nil {
+		var err error
+		if data, err = t.data(r); err != nil {
+			log.Printf("ERROR: template data for %s: %v\n", t.path, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := t.template.Execute(&buf, data); err != nil {
+		log.Printf("ERROR: rendering %s: %v\n", t.path, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// the output can vary per request so the file modification time
+	// can't be used for Last-Modified, only an ETag of the output
+	content := buf.Bytes()
+	w.Header().Set("ETag", contentETag(content))
+	http.ServeContent(w, r, t.path, time.Time{}, bytes.NewReader(content))
+}
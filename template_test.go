@@ -0,0 +1,152 @@
+package prpl
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestHTMLTemplate(t *testing.T) {
+	dir := copyDir(t, "testdata/other")
+	defer os.RemoveAll(dir)
+
+	entrypoint := `<html><head><script nonce="{{.Nonce}}">var locale = {{.Locale}};</script></head><body>other</body></html>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "modern", "index.html"), []byte(entrypoint), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(
+		WithRoot(http.Dir(dir)),
+		WithConfigFile(filepath.Join(dir, "polymer.json")),
+		WithRouteTemplate(HTMLTemplate(func(r *http.Request) (interface{}, error) {
+			return map[string]string{
+				"Nonce":  r.URL.Query().Get("nonce"),
+				"Locale": "en</script>",
+			}, nil
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := get(p, "/?nonce=abc", "unknown browser")
+	body := w.Body.String()
+	if !strings.Contains(body, `<script nonce="abc">var locale = "en\u003c/script\u003e";</script>`) {
+		t.Errorf("expected rendered template: got %s", body)
+	}
+	if w.Header().Get("Last-Modified") != "" {
+		t.Error("expected no Last-Modified header for rendered output")
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" || etag != contentETag([]byte(body)) {
+		t.Errorf("expected ETag of rendered output: got %s", etag)
+	}
+
+	// the same output is not modified
+	r := httptest.NewRequest("GET", "/?nonce=abc", nil)
+	r.Header.Set("If-None-Match", etag)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, r)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected status 304: got %d", rec.Code)
+	}
+
+	// different output is sent in full
+	r = httptest.NewRequest("GET", "/?nonce=def", nil)
+	r.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("expected status 200 with new ETag: got %d %s", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	// invalid templates are reported when the builds are loaded
+	if err := ioutil.WriteFile(filepath.Join(dir, "modern", "index.html"), []byte("{{.Nonce"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(r.Context()); err == nil {
+		t.Error("expected error for invalid template")
+	}
+
+	// templates can be rendered without data
+	entrypoint = `<html><body>{{if .}}data{{else}}no data{{end}}</body></html>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "modern", "index.html"), []byte(entrypoint), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err = New(
+		WithRoot(http.Dir(dir)),
+		WithConfigFile(filepath.Join(dir, "polymer.json")),
+		WithRouteTemplate(HTMLTemplate(nil)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := get(p, "/", "unknown browser"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<body>no data</body>") {
+		t.Errorf("expected template rendered without data: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDefaultTemplateCompression(t *testing.T) {
+	p := newTestServer(t, WithVersion("v1"))
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"": func(r io.Reader) (io.Reader, error) {
+			return r, nil
+		},
+		"gzip": func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		"br": func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		},
+	}
+
+	etags := map[string]string{}
+	for _, acceptEncoding := range []string{"", "gzip", "gzip, br"} {
+		r := httptest.NewRequest("GET", "/view1", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+
+		encoding := w.Header().Get("Content-Encoding")
+		decoder, ok := decoders[encoding]
+		if !ok || w.Code != http.StatusOK {
+			t.Fatalf("%q unexpected response %d %s", acceptEncoding, w.Code, encoding)
+		}
+		body, err := decoder(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(body)
+		if err != nil || !strings.Contains(string(data), `<base href="/v1/fallback/">`) {
+			t.Errorf("%q expected decoded entrypoint: got %s %v", acceptEncoding, data, err)
+		}
+
+		etag := w.Header().Get("ETag")
+		if strings.HasPrefix(etag, "W/") || etags[etag] != "" {
+			t.Errorf("%q expected unique strong ETag: got %s", acceptEncoding, etag)
+		}
+		etags[etag] = encoding
+
+		r.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		if w.Code != http.StatusNotModified {
+			t.Errorf("%q expected status 304: got %d", acceptEncoding, w.Code)
+		}
+	}
+
+	if len(etags) != 3 {
+		t.Errorf("expected identity, gzip and br responses: got %v", etags)
+	}
+}
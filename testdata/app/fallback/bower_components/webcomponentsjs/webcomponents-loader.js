@@ -0,0 +1 @@
+// fallback webcomponents-loader.js
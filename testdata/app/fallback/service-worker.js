@@ -0,0 +1 @@
+// fallback service worker
@@ -0,0 +1 @@
+// fallback my-view1.js
@@ -0,0 +1 @@
+// fallback my-view2.js
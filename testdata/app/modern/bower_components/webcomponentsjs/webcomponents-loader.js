@@ -0,0 +1 @@
+// modern webcomponents-loader.js
@@ -0,0 +1 @@
+// modern service worker
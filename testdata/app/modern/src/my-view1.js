@@ -0,0 +1 @@
+// modern my-view1.js
@@ -0,0 +1 @@
+// modern my-view2.js
@@ -0,0 +1 @@
+// shared absolute.js
@@ -0,0 +1 @@
+// shared parent.js
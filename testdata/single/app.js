@@ -0,0 +1 @@
+// single app.js
@@ -0,0 +1,185 @@
+package prpl
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"encoding/json"
+	"net/http"
+)
+
+type (
+	// entrypointTransform modifies the rendered entrypoint for a
+	// request. If it fails the output is left unmodified.
+	entrypointTransform struct {
+		name string
+
+		// private is set if the output is specific to the user
+		// so it must not be stored by shared caches
+		private bool
+
+		apply func(r *http.Request, body []byte) ([]byte, error)
+	}
+
+	// transformTemplate renders the entrypoint using the underlying
+	// template and then applies the transforms to the output
+	transformTemplate struct {
+		path       string
+		next       Template
+		transforms []*entrypointTransform
+	}
+
+	// responseBuffer captures a response in memory
+	responseBuffer struct {
+		header http.Header
+		code   int
+		body   bytes.Buffer
+	}
+
+	// InitialStateFn provides the initial application state for a
+	// request which is injected into the entrypoint as JSON
+	InitialStateFn func(r *http.Request) (interface{}, error)
+)
+
+// headers that are removed so the underlying template renders
+// the full, uncompressed output and are recalculated after
+// transforming it
+var (
+	conditionalHeaders = []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range", "Range", "Accept-Encoding"}
+	validatorHeaders   = []string{"Content-Length", "Content-Range", "Content-Encoding", "ETag", "Last-Modified"}
+)
+
+// initialStateID is the id of the script element containing the initial state
+const initialStateID = "initial-state"
+
+func (t *transformTemplate) Render(w http.ResponseWriter, r *http.Request) {
+	inner := r.Clone(r.Context())
+	inner.Method = http.MethodGet
+	for _, header := range conditionalHeaders {
+		inner.Header.Del(header)
+	}
+
+	buf := newResponseBuffer()
+	t.next.Render(buf, inner)
+
+	// the Vary header is added to so the detection headers are kept
+	h := w.Header()
+	for key, values := range buf.header {
+		if key == "Vary" {
+			h[key] = append(h[key], values...)
+			continue
+		}
+		h[key] = values
+	}
+
+	if buf.code != http.StatusOK {
+		w.WriteHeader(buf.code)
+		w.Write(buf.body.Bytes())
+		return
+	}
+
+	body := buf.body.Bytes()
+	for _, transform := range t.transforms {
+		transformed, err := transform.apply(r, body)
+		if err != nil {
+			log.Printf("WARNING: %s for %s: %v\n", transform.name, t.path, err)
+			continue
+		}
+		body = transformed
+		if transform.private {
+			h.Set("Cache-Control", "private, max-age=0")
+		}
+	}
+
+	for _, header := range validatorHeaders {
+		h.Del(header)
+	}
+
+	// the transformed output is compressed as it can't be precompressed
+	h.Add("Vary", "Accept-Encoding")
+	if v := compressResponse(r, body); v != nil {
+		h.Set("Content-Encoding", v.encoding)
+		h.Set("ETag", v.etag)
+		http.ServeContent(w, r, t.path, time.Time{}, bytes.NewReader(v.data))
+		return
+	}
+	h.Set("ETag", contentETag(body))
+	http.ServeContent(w, r, t.path, time.Time{}, bytes.NewReader(body))
+}
+
+// initialStateTransform injects the JSON encoded initial state into
+// the head of the entrypoint. The JSON encoder escapes <, > and & so
+// the state can't close the script element.
+func initialStateTransform(state InitialStateFn, limit int) *entrypointTransform {
+	return &entrypointTransform{
+		name:    "initial state",
+		private: true,
+		apply: func(r *http.Request, body []byte) ([]byte, error) {
+			value, err := state(r)
+			if err != nil {
+				return nil, err
+			}
+
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			if limit > 0 && len(data) > limit {
+				return nil, fmt.Errorf("state is %d bytes, limit is %d", len(data), limit)
+			}
+
+			script := make([]byte, 0, len(data)+64)
+			script = append(script, `<script type="application/json" id="`+initialStateID+`">`...)
+			script = append(script, data...)
+			script = append(script, "</script>\n"...)
+
+			return insertBeforeHeadEnd(body, script)
+		},
+	}
+}
+
+// insertBeforeHeadEnd inserts content before the closing head tag
+func insertBeforeHeadEnd(body, content []byte) ([]byte, error) {
+	i := indexFold(body, "</head>")
+	if i == -1 {
+		return nil, fmt.Errorf("entrypoint has no </head>")
+	}
+
+	result := make([]byte, 0, len(body)+len(content))
+	result = append(result, body[:i]...)
+	result = append(result, content...)
+	result = append(result, body[i:]...)
+	return result, nil
+}
+
+// indexFold returns the index of the first case-insensitive
+// match of the ASCII tag in body, or -1 if it isn't present
+func indexFold(body []byte, tag string) int {
+	for i := 0; i+len(tag) <= len(body); i++ {
+		if bytes.EqualFold(body[i:i+len(tag)], []byte(tag)) {
+			return i
+		}
+	}
+	return -1
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{
+		header: http.Header{},
+		code:   http.StatusOK,
+	}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(code int) {
+	b.code = code
+}
+
+func (b *responseBuffer) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
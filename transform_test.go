@@ -0,0 +1,131 @@
+package prpl
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestInitialState(t *testing.T) {
+	var state interface{}
+	var stateErr error
+
+	p := newTestServer(t,
+		WithInitialState(func(r *http.Request) (interface{}, error) {
+			return state, stateErr
+		}),
+		WithInitialStateLimit(100),
+	)
+
+	tests := []struct {
+		state  interface{}
+		err    error
+		expect string
+	}{
+		// state is escaped so it can't close the script element
+		{
+			map[string]string{"user": "</script><script>alert(1)</script>"},
+			nil,
+			`<script type="application/json" id="initial-state">{"user":"\u003c/script\u003e\u003cscript\u003ealert(1)\u003c/script\u003e"}</script>` + "\n</head>",
+		},
+
+		// errors and state over the limit serve the unmodified entrypoint
+		{nil, errors.New("failed"), "</title>\n  <link rel=\"import\" href=\"src/my-app.html\">\n</head>"},
+		{strings.Repeat("x", 100), nil, "</title>\n  <link rel=\"import\" href=\"src/my-app.html\">\n</head>"},
+	}
+
+	for _, test := range tests {
+		state, stateErr = test.state, test.err
+		w := get(p, "/", "unknown browser")
+		body := w.Body.String()
+		if w.Code != http.StatusOK || !strings.Contains(body, test.expect) {
+			t.Errorf("expected body to contain %q: got %d %s", test.expect, w.Code, body)
+		}
+		if etag := w.Header().Get("ETag"); etag != contentETag([]byte(body)) {
+			t.Errorf("expected ETag of transformed output: got %s", etag)
+		}
+	}
+
+	// user specific state must not be cached by shared caches
+	state, stateErr = map[string]string{"user": "test"}, nil
+	w := get(p, "/", "unknown browser")
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "private, max-age=0" {
+		t.Errorf("expected private Cache-Control: got %s", cacheControl)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, r)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected status 304: got %d", rec.Code)
+	}
+}
+
+func TestTransformHeaders(t *testing.T) {
+	p := newTestServer(t,
+		WithRouteMeta(RouteMetadata{"/view1": {Title: "View One"}}),
+		WithInitialState(func(r *http.Request) (interface{}, error) {
+			return map[string]string{"user": "test"}, nil
+		}),
+	)
+
+	etags := map[string]bool{}
+	for _, acceptEncoding := range []string{"", "gzip"} {
+		r := httptest.NewRequest("GET", "/view1", nil)
+		r.Header.Set("User-Agent", "unknown browser")
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+
+		// the detection headers are kept
+		vary := strings.Join(w.Header()["Vary"], ", ")
+		for _, header := range []string{"User-Agent", "Sec-CH-UA", "Accept-Encoding"} {
+			if !strings.Contains(vary, header) {
+				t.Errorf("%q expected Vary to include %s: got %s", acceptEncoding, header, vary)
+			}
+		}
+
+		// the transformed output is compressed if it is accepted
+		encoding := w.Header().Get("Content-Encoding")
+		if encoding != acceptEncoding {
+			t.Errorf("%q expected Content-Encoding %q: got %q", acceptEncoding, acceptEncoding, encoding)
+		}
+		body := w.Body.Bytes()
+		if etag := w.Header().Get("ETag"); etag != contentETag(body) || etags[etag] {
+			t.Errorf("%q expected a distinct ETag of the output: got %s", acceptEncoding, etag)
+		} else {
+			etags[etag] = true
+		}
+		if encoding == "gzip" {
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, err = ioutil.ReadAll(zr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, expect := range []string{"<title>View One</title>", `id="initial-state"`} {
+			if !strings.Contains(string(body), expect) {
+				t.Errorf("%q expected body to contain %q: got %s", acceptEncoding, expect, body)
+			}
+		}
+	}
+}
+
+func TestInsertBeforeHeadEnd(t *testing.T) {
+	result, err := insertBeforeHeadEnd([]byte("<html><HEAD><title>İ</title></HEAD><body></body>"), []byte("<meta>"))
+	if err != nil || string(result) != "<html><HEAD><title>İ</title><meta></HEAD><body></body>" {
+		t.Errorf("unexpected result %s %v", result, err)
+	}
+
+	if _, err := insertBeforeHeadEnd([]byte("<html><body></body>"), []byte("<meta>")); err == nil {
+		t.Error("expected error when there is no head")
+	}
+}
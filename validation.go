@@ -0,0 +1,68 @@
+package prpl
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// BuildError is a problem found when loading a build. Fatal
+	// errors mean the build cannot be served, otherwise the build
+	// is served without the feature that caused the problem.
+	BuildError struct {
+		Build string
+		Path  string
+		Fatal bool
+		Err   error
+	}
+
+	// ValidationErrors is the report of every problem found
+	// when loading the builds
+	ValidationErrors []*BuildError
+)
+
+func (e *BuildError) Error() string {
+	name := e.Build
+	if name == "" {
+		name = "(default)"
+	}
+	if e.Path == "" {
+		return fmt.Sprintf("build %s: %v", name, e.Err)
+	}
+	return fmt.Sprintf("build %s: %s: %v", name, e.Path, e.Err)
+}
+
+func (v ValidationErrors) Error() string {
+	switch len(v) {
+	case 0:
+		return "no errors"
+	case 1:
+		return v[0].Error()
+	}
+
+	messages := make([]string, len(v))
+	for i, err := range v {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d build errors:\n\t%s", len(v), strings.Join(messages, "\n\t"))
+}
+
+// add records a problem with a build
+func (v *ValidationErrors) add(build, path string, fatal bool, err error) {
+	*v = append(*v, &BuildError{
+		Build: build,
+		Path:  path,
+		Fatal: fatal,
+		Err:   err,
+	})
+}
+
+// fatal reports whether any of the errors is fatal
+func (v ValidationErrors) fatal() bool {
+	for _, err := range v {
+		if err.Fatal {
+			return true
+		}
+	}
+	return false
+}